@@ -6,8 +6,10 @@
 package ccompile
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/piot/deps/src/depsbuild"
 	"github.com/piot/deps/src/depslib"
@@ -91,12 +93,10 @@ func findPlatformSpecific(depsPath string, node *depslib.DependencyNode) string
 	return platformSpecific(depsPath, node, fallbackPlatformName)
 }
 
-func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactType) ([]string, error) {
-	depsPath := filepath.Join(info.PackageRootPath, "deps/")
-
+func dependencySourceLibs(depsPath string, nodes []*depslib.DependencyNode) ([]string, error) {
 	var sourceLibs []string
 
-	for _, node := range info.RootNodes {
+	for _, node := range nodes {
 		libPath := filepath.Join(depsPath, node.ShortName(), "src/lib/")
 		if directoryExists(libPath) {
 			allDirs, recursiveErr := libRecursive(libPath)
@@ -128,7 +128,13 @@ func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactTy
 		}
 	}
 
-	ownSrcLib := filepath.Join(info.PackageRootPath, "src/lib")
+	return sourceLibs, nil
+}
+
+func ownSourceLibs(packageRootPath string) ([]string, error) {
+	var sourceLibs []string
+
+	ownSrcLib := filepath.Join(packageRootPath, "src/lib")
 	if directoryExists(ownSrcLib) {
 		allOwnSrcLib, allOwnSrcLibErr := libRecursive(ownSrcLib)
 		if allOwnSrcLibErr != nil {
@@ -138,7 +144,7 @@ func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactTy
 		sourceLibs = append(sourceLibs, allOwnSrcLib...)
 	}
 
-	glfwSpecific := filepath.Join(info.PackageRootPath, "src/platform/glfw")
+	glfwSpecific := filepath.Join(packageRootPath, "src/platform/glfw")
 	//nolint: nestif
 	if directoryExists(glfwSpecific) {
 		allDirs, recursiveErr := libRecursive(glfwSpecific)
@@ -149,6 +155,78 @@ func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactTy
 		sourceLibs = append(sourceLibs, allDirs...)
 	}
 
+	return sourceLibs, nil
+}
+
+func infoDepsPath(info *depslib.DependencyInfo) string {
+	if info.DepsPath != "" {
+		return info.DepsPath
+	}
+	return filepath.Join(info.PackageRootPath, "deps/")
+}
+
+func includePathsAndDefines(info *depslib.DependencyInfo, depsPath string) ([]string, []string) {
+	var includePaths []string
+
+	includePaths = append(includePaths, filepath.Join(depsPath, "include"))
+	includePaths = append(includePaths, filepath.Join(info.PackageRootPath, "src/include"))
+
+	var defines []string
+	defines = append(defines, "_POSIX_C_SOURCE=200112L")
+	defines = append(defines, "CONFIGURATION_DEBUG")
+	defines = append(defines, "TYRAN_CONFIGURATION_DEBUG")
+	for _, feature := range info.Features {
+		defines = append(defines, depslib.FeatureDefine(feature))
+	}
+
+	return includePaths, defines
+}
+
+func compileFlags() []string {
+	flags := []string{"-g", "-O0", "--std=c11",
+		"-Wall", "-Weverything",
+		"-Wno-disabled-macro-expansion", "-Wno-reserved-id-macro", "-Wno-documentation", "-Wno-comma",
+		"-Wno-double-promotion", "-Wno-c++-compat", "-Wno-covered-switch-default",
+		// "-pedantic", "-Werror",
+		"-Wno-sign-conversion", "-Wno-conversion", "-Wno-unused-parameter",
+		"-Wno-cast-align",
+		"-Wno-padded", "-Wno-cast-qual",
+		"-Wno-documentation-unknown-command",
+		"-Wno-gnu-folding-constant", "-Wno-unused-macros"}
+
+	operatingSytem := depsbuild.DetectOS()
+	switch operatingSytem {
+	case depsbuild.MacOS:
+		flags = append(flags, "-Wno-extra-semi")
+	default:
+		flags = append(flags, "-Wno-extra-semi-stmt")
+	}
+
+	return flags
+}
+
+type buildSettings struct {
+	flags        []string
+	sourceLibs   []string
+	includePaths []string
+	defines      []string
+	linkFlags    []string
+}
+
+func applicationSettings(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactType) (*buildSettings, error) {
+	depsPath := infoDepsPath(info)
+
+	sourceLibs, dependencyErr := dependencySourceLibs(depsPath, info.RootNodes)
+	if dependencyErr != nil {
+		return nil, dependencyErr
+	}
+
+	ownLibs, ownErr := ownSourceLibs(info.PackageRootPath)
+	if ownErr != nil {
+		return nil, ownErr
+	}
+	sourceLibs = append(sourceLibs, ownLibs...)
+
 	useSDL := false
 
 	artifactType := info.RootNode.ArtifactType()
@@ -200,38 +278,114 @@ func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactTy
 		linkFlags = append(linkFlags, "-fPIC")
 	}
 
-	var includePaths []string
-
-	includePaths = append(includePaths, filepath.Join(depsPath, "include"))
-	includePaths = append(includePaths, filepath.Join(info.PackageRootPath, "src/include"))
+	includePaths, defines := includePathsAndDefines(info, depsPath)
 
 	if useSDL {
 		includePaths = append(includePaths, "/usr/include/SDL2/")
 	}
 
-	var defines []string
-	defines = append(defines, "_POSIX_C_SOURCE=200112L")
-	defines = append(defines, "CONFIGURATION_DEBUG")
-	defines = append(defines, "TYRAN_CONFIGURATION_DEBUG")
+	return &buildSettings{flags: compileFlags(), sourceLibs: sourceLibs, includePaths: includePaths, defines: defines,
+		linkFlags: linkFlags}, nil
+}
 
-	flags := []string{"-g", "-O0", "--std=c11",
-		"-Wall", "-Weverything",
-		"-Wno-disabled-macro-expansion", "-Wno-reserved-id-macro", "-Wno-documentation", "-Wno-comma",
-		"-Wno-double-promotion", "-Wno-c++-compat", "-Wno-covered-switch-default",
-		// "-pedantic", "-Werror",
-		"-Wno-sign-conversion", "-Wno-conversion", "-Wno-unused-parameter",
-		"-Wno-cast-align",
-		"-Wno-padded", "-Wno-cast-qual",
-		"-Wno-documentation-unknown-command",
-		"-Wno-gnu-folding-constant", "-Wno-unused-macros"}
+func Build(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactType) ([]string, error) {
+	settings, settingsErr := applicationSettings(info, artifactTypeOverride)
+	if settingsErr != nil {
+		return nil, settingsErr
+	}
 
-	operatingSytem := depsbuild.DetectOS()
-	switch operatingSytem {
-	case depsbuild.MacOS:
-		flags = append(flags, "-Wno-extra-semi")
-	default:
-		flags = append(flags, "-Wno-extra-semi-stmt")
+	return depsbuild.Build(settings.flags, settings.sourceLibs, settings.includePaths, settings.defines, settings.linkFlags)
+}
+
+// TestSourceFiles returns src/test/*.c of the package. Every file is built into its own test executable.
+func TestSourceFiles(info *depslib.DependencyInfo) ([]string, error) {
+	return filepath.Glob(filepath.Join(info.PackageRootPath, "src/test/*.c"))
+}
+
+func testSettings(info *depslib.DependencyInfo) (*buildSettings, error) {
+	depsPath := infoDepsPath(info)
+	nodes := append(append([]*depslib.DependencyNode{}, info.RootNodes...), info.DevelopmentNodes...)
+	sourceLibs, dependencyErr := dependencySourceLibs(depsPath, nodes)
+	if dependencyErr != nil {
+		return nil, dependencyErr
+	}
+
+	ownLibs, ownErr := ownSourceLibs(info.PackageRootPath)
+	if ownErr != nil {
+		return nil, ownErr
+	}
+	sourceLibs = append(sourceLibs, ownLibs...)
+
+	includePaths, defines := includePathsAndDefines(info, depsPath)
+	defines = append(defines, "DEPS_TEST")
+
+	return &buildSettings{flags: compileFlags(), sourceLibs: sourceLibs, includePaths: includePaths, defines: defines,
+		linkFlags: []string{"-lm"}}, nil
+}
+
+// BuildTests builds every test source file together with the package, its dependencies and its development
+// dependencies. The executables are written to outputDirectory and named after the test source file.
+func BuildTests(info *depslib.DependencyInfo, outputDirectory string) ([]string, error) {
+	testFiles, globErr := TestSourceFiles(info)
+	if globErr != nil {
+		return nil, globErr
+	}
+
+	settings, settingsErr := testSettings(info)
+	if settingsErr != nil {
+		return nil, settingsErr
+	}
+
+	var executables []string
+	for _, testFile := range testFiles {
+		testName := strings.TrimSuffix(filepath.Base(testFile), ".c")
+		outputFilename := filepath.Join(outputDirectory, testName)
+		if err := depsbuild.BuildExecutable(outputFilename, settings.flags, settings.sourceLibs, []string{testFile},
+			settings.includePaths, settings.defines, settings.linkFlags); err != nil {
+			return nil, fmt.Errorf("could not build test '%v': %w", testName, err)
+		}
+		executables = append(executables, outputFilename)
+	}
+
+	return executables, nil
+}
+
+// CompileCommands returns one entry for every source file that Build would compile. With includeTests, the test
+// source files follow with the flags that BuildTests uses, which needs the development dependencies in info.
+func CompileCommands(info *depslib.DependencyInfo, artifactTypeOverride depslib.ArtifactType, includeTests bool) ([]depsbuild.CompileCommand, error) {
+	settings, settingsErr := applicationSettings(info, artifactTypeOverride)
+	if settingsErr != nil {
+		return nil, settingsErr
+	}
+
+	commands, commandsErr := depsbuild.CompileCommands(settings.flags, settings.sourceLibs, nil, settings.includePaths,
+		settings.defines)
+	if commandsErr != nil {
+		return nil, commandsErr
+	}
+
+	if !includeTests {
+		return commands, nil
+	}
+
+	testFiles, globErr := TestSourceFiles(info)
+	if globErr != nil {
+		return nil, globErr
+	}
+	if len(testFiles) == 0 {
+		return commands, nil
+	}
+
+	tests, testsErr := testSettings(info)
+	if testsErr != nil {
+		return nil, testsErr
+	}
+
+	testCommands, testCommandsErr := depsbuild.CompileCommands(tests.flags, nil, testFiles, tests.includePaths,
+		tests.defines)
+	if testCommandsErr != nil {
+		return nil, testCommandsErr
 	}
 
-	return depsbuild.Build(flags, sourceLibs, includePaths, defines, linkFlags)
+	return append(commands, testCommands...), nil
 }
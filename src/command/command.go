@@ -6,20 +6,24 @@
 package command
 
 import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/piot/deps/src/ccompile"
+	"github.com/piot/deps/src/depsbuild"
 	"github.com/piot/deps/src/depslib"
+	"github.com/piot/deps/src/depsrun"
+	"github.com/piot/deps/src/depstest"
 )
 
 type Options struct {
-	Mode                       depslib.Mode
-	ForceClean                 bool
-	UseDevelopmentDependencies bool
-	Artifact                   depslib.ArtifactType
-	LocalPackageRoot           string
-	TargetDepsPath             string
+	depslib.SetupOptions
+	Artifact depslib.ArtifactType
 }
 
 func setupDependencies(foundConfs []string, options Options) (*depslib.DependencyInfo, error) {
-	dependencyInfo, err := depslib.SetupDependencies(foundConfs[0], options.Mode, options.ForceClean, options.UseDevelopmentDependencies, options.LocalPackageRoot, options.TargetDepsPath)
+	dependencyInfo, err := depslib.SetupDependencies(foundConfs[0], options.SetupOptions)
 	return dependencyInfo, err
 }
 
@@ -35,3 +39,165 @@ func Fetch(foundConfs []string, options Options, showTree bool) error {
 
 	return nil
 }
+
+func Graph(foundConfs []string, options Options, format string) error {
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	graph := depslib.NewGraph(info)
+	switch format {
+	case "json":
+		return graph.WriteJSON(os.Stdout)
+	case "dot":
+		return graph.WriteDot(os.Stdout)
+	case "text":
+		info.RootNode.Print(0)
+		return nil
+	}
+
+	return fmt.Errorf("unknown graph format '%v'", format)
+}
+
+func Why(foundConfs []string, options Options, packageName string) error {
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	paths, whyErr := depslib.Why(info.RootNode, packageName)
+	if whyErr != nil {
+		return whyErr
+	}
+
+	for _, dependencyPath := range paths {
+		fmt.Printf("%v\n", dependencyPath)
+		redundantEdges := dependencyPath.RedundantEdges()
+		for index := 0; index < len(dependencyPath.Nodes)-1; index++ {
+			alreadyRequiredBy, isRedundant := redundantEdges[index]
+			if !isRedundant {
+				continue
+			}
+			fmt.Printf("  redundant: '%v' includes '%v', but it is already required by %v\n", dependencyPath.Nodes[index].Name(),
+				dependencyPath.Nodes[index+1].Name(), alreadyRequiredBy)
+		}
+	}
+
+	return nil
+}
+
+func Build(foundConfs []string, options Options) error {
+	if err := depslib.ValidateHostTargetOS(options.TargetOS); err != nil {
+		return err
+	}
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	artifacts, buildErr := ccompile.Build(info, options.Artifact)
+	if buildErr != nil {
+		return buildErr
+	}
+
+	for _, artifact := range artifacts {
+		fmt.Printf("built '%v'\n", artifact)
+	}
+
+	return nil
+}
+
+// Compdb fetches like Build does. The test source files are only included with --dev, since they are compiled
+// against the development dependencies.
+func Compdb(foundConfs []string, options Options, outputFilename string) error {
+	if err := depslib.ValidateHostTargetOS(options.TargetOS); err != nil {
+		return err
+	}
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	commands, commandsErr := ccompile.CompileCommands(info, options.Artifact, options.UseDevelopmentDependencies)
+	if commandsErr != nil {
+		return commandsErr
+	}
+
+	if err := depsbuild.WriteCompileCommands(outputFilename, commands); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %d entries to '%v'\n", len(commands), outputFilename)
+
+	return nil
+}
+
+func Run(foundConfs []string, options Options, runArgs []string) error {
+	if err := depslib.ValidateHostTargetOS(options.TargetOS); err != nil {
+		return err
+	}
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	return depsrun.Run(info, options.Artifact, runArgs)
+}
+
+// Test always resolves the development dependencies, since the tests are built against them.
+func Test(foundConfs []string, options Options, junitFilename string) error {
+	if err := depslib.ValidateHostTargetOS(options.TargetOS); err != nil {
+		return err
+	}
+	options.UseDevelopmentDependencies = true
+	info, depsErr := setupDependencies(foundConfs, options)
+	if depsErr != nil {
+		return depsErr
+	}
+
+	return depstest.Test(info, junitFilename)
+}
+
+func CacheList() error {
+	downloads, err := depslib.NewDownloadCache(true)
+	if err != nil {
+		return err
+	}
+
+	entries, listErr := downloads.List()
+	if listErr != nil {
+		return listErr
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%v %v %v %v %v\n", entry.Name, entry.Ref, entry.Commit, entry.Checksum, entry.LastUsed.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func CachePrune(maxAge time.Duration) error {
+	downloads, err := depslib.NewDownloadCache(true)
+	if err != nil {
+		return err
+	}
+
+	removedCount, pruneErr := downloads.Prune(maxAge)
+	if pruneErr != nil {
+		return pruneErr
+	}
+
+	fmt.Printf("removed %d archives from '%v'\n", removedCount, downloads.Directory)
+
+	return nil
+}
+
+func CacheClear() error {
+	downloads, err := depslib.NewDownloadCache(true)
+	if err != nil {
+		return err
+	}
+
+	return downloads.Clear()
+}
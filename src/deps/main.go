@@ -8,6 +8,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/piot/deps/src/command"
@@ -18,12 +19,20 @@ var version string
 
 // SharedOptions are command line shared options.
 type SharedOptions struct {
-	Mode                       string `name:"mode" short:"m" enum:"wget,symlink,clone,read" default:"wget" help:"How the dependencies are realized: wget, symlink or clone"`
-	ForceClean                 bool   `name:"clean" default:"false" help:"delete the deps directory"`
-	LocalPackageRoot           string `name:"localPackageRoot" short:"r" default:"" type:"path" help:"root directory of local packages"`
-	TargetDepsPath             string `name:"targetDepsPath" short:"t" default:"" type:"path" help:"deps/ target directory"`
-	UseDevelopmentDependencies bool   `name:"dev" default:"false" help:"include the development dependencies"`
-	Artifact                   string `short:"a" optional:"" help:"override application type"`
+	Mode                       string   `name:"mode" short:"m" enum:"wget,symlink,clone,read" default:"wget" help:"How the dependencies are realized: wget, symlink or clone"`
+	ForceClean                 bool     `name:"clean" default:"false" help:"delete the deps directory"`
+	LocalPackageRoot           string   `name:"localPackageRoot" short:"r" default:"" type:"path" help:"root directory of local packages"`
+	TargetDepsPath             string   `name:"targetDepsPath" short:"t" default:"" type:"path" help:"deps/ target directory"`
+	UseDevelopmentDependencies bool     `name:"dev" default:"false" help:"include the development dependencies"`
+	Artifact                   string   `short:"a" optional:"" help:"override application type"`
+	Update                     bool     `name:"update" default:"false" help:"ignore deps.lock and fetch the latest versions"`
+	Offline                    bool     `name:"offline" default:"false" help:"only use archives from the download cache"`
+	Jobs                       int      `name:"jobs" short:"j" default:"4" help:"number of dependencies to fetch at the same time"`
+	Depth                      int      `name:"depth" default:"0" help:"make shallow clones with this depth in clone mode, 0 clones everything"`
+	GitRemote                  string   `name:"git-remote" enum:"https,ssh" default:"https" help:"clone from github and http git servers using https or ssh"`
+	GitBackend                 string   `name:"git-backend" enum:"exec,native" default:"exec" help:"clone using the git executable (exec) or the built in git implementation (native)"`
+	Features                   []string `name:"features" sep:"," help:"comma separated features of the package to enable"`
+	TargetOS                   string   `name:"target-os" default:"" help:"fetch the dependencies for linux, macos or windows instead of this operating system, build, run and test only support this operating system"`
 }
 
 // FetchCmd is the options for a fetch.
@@ -32,9 +41,69 @@ type FetchCmd struct {
 	ShowTree bool          `name:"tree" default:"false" help:"show the dependency tree"`
 }
 
+// GraphCmd is the options for showing the dependency graph.
+type GraphCmd struct {
+	Shared SharedOptions `embed:""`
+	Format string        `name:"format" short:"f" enum:"json,dot,text" default:"text" help:"output format: json, dot or text"`
+}
+
+// WhyCmd is the options for explaining why a package is a dependency.
+type WhyCmd struct {
+	Shared  SharedOptions `embed:""`
+	Package string        `arg:"" help:"name of the dependency, e.g. piot/tiny-clib"`
+}
+
+// BuildCmd is the options for a build.
+type BuildCmd struct {
+	Shared SharedOptions `embed:""`
+}
+
+// CompdbCmd is the options for writing a compile_commands.json.
+type CompdbCmd struct {
+	Shared SharedOptions `embed:""`
+	Output string        `name:"output" short:"o" default:"compile_commands.json" type:"path" help:"compilation database filename"`
+}
+
+// RunCmd is the options for building and running.
+type RunCmd struct {
+	Shared SharedOptions `embed:""`
+	Args   []string      `arg:"" optional:"" help:"arguments to the executable, after --"`
+}
+
+// TestCmd is the options for building and running the tests in src/test/.
+type TestCmd struct {
+	Shared SharedOptions `embed:""`
+	JUnit  string        `name:"junit" default:"" type:"path" help:"write a JUnit XML report to this file"`
+}
+
+// CacheListCmd lists the download cache.
+type CacheListCmd struct{}
+
+// CachePruneCmd is the options for pruning the download cache.
+type CachePruneCmd struct {
+	MaxAge time.Duration `name:"max-age" default:"720h" help:"remove archives that have not been used for this long"`
+}
+
+// CacheClearCmd removes the download cache.
+type CacheClearCmd struct{}
+
+// CacheCmd is the download cache commands.
+type CacheCmd struct {
+	List  CacheListCmd  `cmd:"" help:"list the cached archives"`
+	Prune CachePruneCmd `cmd:"" help:"remove old and unused archives"`
+	Clear CacheClearCmd `cmd:"" help:"remove all cached archives"`
+}
+
 // Options are all the command line options.
 type Options struct {
-	Fetch FetchCmd `cmd:""`
+	Fetch  FetchCmd  `cmd:""`
+	Graph  GraphCmd  `cmd:""`
+	Why    WhyCmd    `cmd:""`
+	Build  BuildCmd  `cmd:""`
+	Compdb CompdbCmd `cmd:"" help:"write compile_commands.json for clangd and other tools, use --dev to include src/test"`
+	Run    RunCmd    `cmd:""`
+	Test   TestCmd   `cmd:""`
+	Cache  CacheCmd  `cmd:""`
 }
 
 func stringToArtifactType(appType string) depslib.ArtifactType {
@@ -64,9 +133,21 @@ func sharedOptionsToGeneralOptions(shared SharedOptions) command.Options {
 		mode = depslib.ReadLocal
 	}
 
-	generalOptions := command.Options{Mode: mode, ForceClean: shared.ForceClean,
+	gitRemote := depslib.HTTPSRemote
+	if shared.GitRemote == "ssh" {
+		gitRemote = depslib.SSHRemote
+	}
+
+	gitBackend := depslib.ExecGitBackend
+	if shared.GitBackend == "native" {
+		gitBackend = depslib.NativeGitBackend
+	}
+
+	setupOptions := depslib.SetupOptions{Mode: mode, ForceClean: shared.ForceClean,
 		UseDevelopmentDependencies: shared.UseDevelopmentDependencies, LocalPackageRoot: shared.LocalPackageRoot,
-		TargetDepsPath: shared.TargetDepsPath, Artifact: stringToArtifactType(shared.Artifact)}
+		TargetDepsPath: shared.TargetDepsPath, Update: shared.Update, Offline: shared.Offline, JobCount: shared.Jobs,
+		Git: depslib.GitOptions{Depth: shared.Depth, Remote: gitRemote, Backend: gitBackend}, Features: shared.Features, TargetOS: shared.TargetOS}
+	generalOptions := command.Options{SetupOptions: setupOptions, Artifact: stringToArtifactType(shared.Artifact)}
 
 	return generalOptions
 }
@@ -81,6 +162,81 @@ func (o *FetchCmd) Run() error {
 	return command.Fetch(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.ShowTree)
 }
 
+// Run is called if a graph command was issued.
+func (o *GraphCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Graph(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.Format)
+}
+
+// Run is called if a why command was issued.
+func (o *WhyCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Why(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.Package)
+}
+
+// Run is called if a build command was issued.
+func (o *BuildCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Build(foundConfs, sharedOptionsToGeneralOptions(o.Shared))
+}
+
+// Run is called if a compdb command was issued.
+func (o *CompdbCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Compdb(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.Output)
+}
+
+// Run is called if a run command was issued.
+func (o *RunCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Run(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.Args)
+}
+
+// Run is called if a test command was issued.
+func (o *TestCmd) Run() error {
+	foundConfs, foundErr := depslib.FindClosestConfigurationFiles(".")
+	if foundErr != nil {
+		return foundErr
+	}
+
+	return command.Test(foundConfs, sharedOptionsToGeneralOptions(o.Shared), o.JUnit)
+}
+
+// Run is called if a cache list command was issued.
+func (o *CacheListCmd) Run() error {
+	return command.CacheList()
+}
+
+// Run is called if a cache prune command was issued.
+func (o *CachePruneCmd) Run() error {
+	return command.CachePrune(o.MaxAge)
+}
+
+// Run is called if a cache clear command was issued.
+func (o *CacheClearCmd) Run() error {
+	return command.CacheClear()
+}
+
 func main() {
 	ctx := kong.Parse(&Options{})
 
@@ -95,24 +95,41 @@ func SuffixExtension(sources []string, suffix string, wd string) string {
 }
 
 func Build(flags []string, sources []string, includes []string, defines []string, linkFlags []string) ([]string, error) {
-	dir, err := os.Getwd()
-	if err != nil {
+	outputFilename := "./a.out"
+	_ = os.Remove(outputFilename)
+
+	if err := compile("", flags, sources, nil, includes, defines, linkFlags); err != nil {
 		return nil, err
 	}
 
-	outputFilename := "./a.out"
+	return []string{outputFilename}, nil
+}
+
+// BuildExecutable compiles the *.c in the source directories and the source files into outputFilename.
+func BuildExecutable(outputFilename string, flags []string, sourceDirectories []string, sourceFiles []string, includes []string, defines []string, linkFlags []string) error {
 	_ = os.Remove(outputFilename)
 
-	compileSources := SuffixExtension(sources, "*.c", dir)
+	return compile(outputFilename, flags, sourceDirectories, sourceFiles, includes, defines, linkFlags)
+}
+
+func compile(outputFilename string, flags []string, sourceDirectories []string, sourceFiles []string, includes []string, defines []string, linkFlags []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	compileSources := SuffixExtension(sourceDirectories, "*.c", dir)
+	if len(sourceFiles) > 0 {
+		compileSources += " " + PrefixFile(sourceFiles, "", dir)
+	}
 	allDefines := append(defines, OSDefine())
 	defineString := Prefix(allDefines, "-D ")
 	includeString := PrefixFile(includes, "-I ", dir)
 	flagString := strings.Join(flags, " ")
-	linkFlagString := strings.Join(linkFlags, " ")
-	executeErr := Execute("clang", flagString, compileSources, defineString, includeString, linkFlagString)
-	if executeErr != nil {
-		return nil, executeErr
+	if outputFilename != "" {
+		flagString += " -o " + outputFilename
 	}
+	linkFlagString := strings.Join(linkFlags, " ")
 
-	return []string{outputFilename}, nil
+	return Execute("clang", flagString, compileSources, defineString, includeString, linkFlagString)
 }
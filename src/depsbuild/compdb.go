@@ -0,0 +1,78 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depsbuild
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// CompileCommand is one entry in a compile_commands.json compilation database.
+type CompileCommand struct {
+	Directory string   `json:"directory"`
+	Arguments []string `json:"arguments"`
+	File      string   `json:"file"`
+}
+
+// CompileCommands returns an entry for every *.c in the source directories and for every source file,
+// using the same flags, defines and include paths as Build.
+func CompileCommands(flags []string, sourceDirectories []string, sourceFiles []string, includes []string, defines []string) ([]CompileCommand, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, sourceDirectory := range sourceDirectories {
+		found, globErr := filepath.Glob(filepath.Join(sourceDirectory, "*.c"))
+		if globErr != nil {
+			return nil, globErr
+		}
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	files = append(files, sourceFiles...)
+
+	arguments := append([]string{"clang"}, flags...)
+	for _, define := range append(append([]string{}, defines...), OSDefine()) {
+		arguments = append(arguments, "-D", define)
+	}
+	for _, include := range includes {
+		absoluteInclude, absErr := filepath.Abs(include)
+		if absErr != nil {
+			return nil, absErr
+		}
+		arguments = append(arguments, "-I", absoluteInclude)
+	}
+
+	var commands []CompileCommand
+	for _, file := range files {
+		absoluteFile, absErr := filepath.Abs(file)
+		if absErr != nil {
+			return nil, absErr
+		}
+		fileArguments := append(append([]string{}, arguments...), "-c", absoluteFile)
+		commands = append(commands, CompileCommand{Directory: dir, Arguments: fileArguments, File: absoluteFile})
+	}
+
+	return commands, nil
+}
+
+func WriteCompileCommands(filename string, commands []CompileCommand) error {
+	if commands == nil {
+		commands = []CompileCommand{}
+	}
+
+	octets, marshalErr := json.MarshalIndent(commands, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	return ioutil.WriteFile(filename, append(octets, '\n'), 0o644)
+}
@@ -0,0 +1,256 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+type ArchiveFormat uint8
+
+const (
+	UnknownArchive ArchiveFormat = iota
+	Zip
+	TarGz
+	TarXz
+)
+
+func (f ArchiveFormat) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case TarGz:
+		return "tar.gz"
+	case TarXz:
+		return "tar.xz"
+	}
+	return "unknown"
+}
+
+var archiveMagics = []struct {
+	magic  []byte
+	format ArchiveFormat
+}{
+	{[]byte("PK\x03\x04"), Zip},
+	{[]byte("PK\x05\x06"), Zip},
+	{[]byte("\x1f\x8b"), TarGz},
+	{[]byte("\xfd7zXZ\x00"), TarXz},
+}
+
+func archiveFormatFromExtension(filename string) ArchiveFormat {
+	lowerFilename := strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(lowerFilename, ".zip"):
+		return Zip
+	case strings.HasSuffix(lowerFilename, ".tar.gz") || strings.HasSuffix(lowerFilename, ".tgz"):
+		return TarGz
+	case strings.HasSuffix(lowerFilename, ".tar.xz") || strings.HasSuffix(lowerFilename, ".txz"):
+		return TarXz
+	}
+	return UnknownArchive
+}
+
+// DetectArchiveFormat looks at the first bytes of the file, and falls back to the extension. Archives in the
+// download cache are stored without extension.
+func DetectArchiveFormat(filename string) (ArchiveFormat, error) {
+	file, openErr := os.Open(filename)
+	if openErr != nil {
+		return UnknownArchive, openErr
+	}
+	defer file.Close()
+
+	header := make([]byte, 6)
+	readCount, readErr := io.ReadFull(file, header)
+	if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+		return UnknownArchive, readErr
+	}
+	header = header[:readCount]
+
+	for _, archiveMagic := range archiveMagics {
+		if bytes.HasPrefix(header, archiveMagic.magic) {
+			return archiveMagic.format, nil
+		}
+	}
+
+	if format := archiveFormatFromExtension(filename); format != UnknownArchive {
+		return format, nil
+	}
+
+	return UnknownArchive, fmt.Errorf("'%v' is not a zip, tar.gz or tar.xz archive", filename)
+}
+
+// errStopWalking is returned from an archiveEntryFunc to stop before the end of the archive.
+var errStopWalking = errors.New("stop walking archive")
+
+// archiveEntryFunc is called for each entry in an archive. The reader from open is only valid during the call.
+type archiveEntryFunc func(name string, mode os.FileMode, open func() (io.ReadCloser, error)) error
+
+func decompressingReader(reader io.Reader, format ArchiveFormat) (io.Reader, error) {
+	switch format {
+	case TarGz:
+		return gzip.NewReader(reader)
+	case TarXz:
+		return xz.NewReader(reader)
+	}
+	return nil, fmt.Errorf("archive format %v is not a tar", format)
+}
+
+// walkTar calls entryFunc for the entries in a compressed tar. The pax global header, where git archive stores
+// the commit, is passed to headerFunc instead.
+func walkTar(filename string, format ArchiveFormat, entryFunc archiveEntryFunc, headerFunc func(*tar.Header)) error {
+	file, openErr := os.Open(filename)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+
+	uncompressedReader, decompressErr := decompressingReader(file, format)
+	if decompressErr != nil {
+		return decompressErr
+	}
+
+	tarReader := tar.NewReader(uncompressedReader)
+	for {
+		header, nextErr := tarReader.Next()
+		if nextErr == io.EOF {
+			return nil
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			if headerFunc != nil {
+				headerFunc(header)
+			}
+			continue
+		case tar.TypeLink:
+			return fmt.Errorf("archive entry '%v' is a hard link, which is not supported", header.Name)
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+		if name == "" || name == "." {
+			continue
+		}
+
+		mode := header.FileInfo().Mode()
+		open := func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(tarReader), nil
+		}
+		if header.Typeflag == tar.TypeSymlink {
+			open = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(strings.NewReader(header.Linkname)), nil
+			}
+		}
+
+		if err := entryFunc(name, mode, open); err != nil {
+			return err
+		}
+	}
+}
+
+func walkArchive(filename string, entryFunc archiveEntryFunc) error {
+	format, formatErr := DetectArchiveFormat(filename)
+	if formatErr != nil {
+		return formatErr
+	}
+
+	if format == Zip {
+		return walkZip(filename, entryFunc)
+	}
+
+	return walkTar(filename, format, entryFunc, nil)
+}
+
+func extractArchiveWithLimit(filename string, destinationDirectory string, stripPrefix string, maxSize int64) error {
+	extractor, extractorErr := newExtractor(destinationDirectory, maxSize)
+	if extractorErr != nil {
+		return extractorErr
+	}
+
+	walkErr := walkArchive(filename, func(name string, mode os.FileMode, open func() (io.ReadCloser, error)) error {
+		return extractor.extract(strings.TrimPrefix(name, stripPrefix), mode, open)
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+
+	return extractor.checkAllSymlinks()
+}
+
+// extractArchive extracts a zip, tar.gz or tar.xz archive, removing stripPrefix from the start of each entry,
+// the way github archives have everything in a "<repo>-<ref>/" directory.
+func extractArchive(filename string, destinationDirectory string, stripPrefix string) error {
+	return extractArchiveWithLimit(filename, destinationDirectory, stripPrefix, maxUncompressedArchiveSize)
+}
+
+// archiveTopDirectory returns the directory, including the trailing slash, that all entries in the archive are
+// stored under, or an empty string if there is no such directory. Github names it after the repository and the
+// ref, e.g. "tiny-clib-1.0.2/", so it is read from the archive instead.
+func archiveTopDirectory(filename string) (string, error) {
+	topDirectory := ""
+	hasCommonTopDirectory := true
+
+	walkErr := walkArchive(filename, func(name string, mode os.FileMode, _ func() (io.ReadCloser, error)) error {
+		if mode.IsDir() && !strings.HasSuffix(name, "/") {
+			name += "/"
+		}
+		slashIndex := strings.Index(name, "/")
+		if slashIndex < 0 {
+			hasCommonTopDirectory = false
+			return nil
+		}
+		entryTopDirectory := name[:slashIndex+1]
+		if topDirectory != "" && entryTopDirectory != topDirectory {
+			hasCommonTopDirectory = false
+		}
+		topDirectory = entryTopDirectory
+		return nil
+	})
+	if walkErr != nil {
+		return "", walkErr
+	}
+
+	if !hasCommonTopDirectory {
+		return "", nil
+	}
+
+	return topDirectory, nil
+}
+
+// archiveCommit returns the commit that git archive stores in the archive, or an empty string if there is none.
+func archiveCommit(filename string) string {
+	format, formatErr := DetectArchiveFormat(filename)
+	if formatErr != nil {
+		return ""
+	}
+
+	if format == Zip {
+		return zipCommit(filename)
+	}
+
+	commit := ""
+	_ = walkTar(filename, format, func(string, os.FileMode, func() (io.ReadCloser, error)) error {
+		return errStopWalking
+	}, func(header *tar.Header) {
+		if comment := strings.TrimSpace(header.PAXRecords["comment"]); isCommitHash(comment) {
+			commit = comment
+		}
+	})
+
+	return commit
+}
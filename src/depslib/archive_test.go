@@ -0,0 +1,116 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+const testCommit = "3b5d5c3712955042212316173ccf37be800a6d8c"
+
+func writeTestTar(t *testing.T, filename string, format ArchiveFormat) {
+	file, createErr := os.Create(filename)
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	defer file.Close()
+
+	var compressingWriter io.WriteCloser
+	switch format {
+	case TarGz:
+		compressingWriter = gzip.NewWriter(file)
+	case TarXz:
+		xzWriter, xzErr := xz.NewWriter(file)
+		if xzErr != nil {
+			t.Fatal(xzErr)
+		}
+		compressingWriter = xzWriter
+	}
+
+	tarWriter := tar.NewWriter(compressingWriter)
+	headers := []*tar.Header{
+		{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": testCommit}},
+		{Typeflag: tar.TypeDir, Name: "thunder-1.0.0/", Mode: 0755},
+		{Typeflag: tar.TypeReg, Name: "thunder-1.0.0/deps.toml", Mode: 0644, Size: 4},
+		{Typeflag: tar.TypeSymlink, Name: "thunder-1.0.0/settings.toml", Linkname: "deps.toml", Mode: 0777},
+	}
+	for _, header := range headers {
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tarWriter.Write([]byte("name")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressingWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	for _, format := range []ArchiveFormat{TarGz, TarXz} {
+		directory := t.TempDir()
+		archiveFilename := filepath.Join(directory, "archive-without-extension")
+		writeTestTar(t, archiveFilename, format)
+
+		detectedFormat, detectErr := DetectArchiveFormat(archiveFilename)
+		if detectErr != nil || detectedFormat != format {
+			t.Fatalf("expected %v, got %v %v", format, detectedFormat, detectErr)
+		}
+
+		prefix, prefixErr := archiveTopDirectory(archiveFilename)
+		if prefixErr != nil || prefix != "thunder-1.0.0/" {
+			t.Fatalf("%v: wrong prefix '%v' %v", format, prefix, prefixErr)
+		}
+
+		if commit := archiveCommit(archiveFilename); commit != testCommit {
+			t.Errorf("%v: wrong commit '%v'", format, commit)
+		}
+
+		targetDirectory := filepath.Join(directory, "deps/piot/thunder")
+		if err := extractArchive(archiveFilename, targetDirectory, prefix); err != nil {
+			t.Fatal(err)
+		}
+
+		content, readErr := ioutil.ReadFile(filepath.Join(targetDirectory, "settings.toml"))
+		if readErr != nil || string(content) != "name" {
+			t.Errorf("%v: wrong content %v '%s'", format, readErr, content)
+		}
+	}
+}
+
+func TestDetectArchiveFormatFromExtension(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "release.tgz")
+	if err := ioutil.WriteFile(filename, []byte("??"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	format, err := DetectArchiveFormat(filename)
+	if err != nil || format != TarGz {
+		t.Errorf("expected tar.gz from extension, got %v %v", format, err)
+	}
+
+	unknownFilename := filepath.Join(t.TempDir(), "release.bin")
+	if err := ioutil.WriteFile(unknownFilename, []byte("??"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DetectArchiveFormat(unknownFilename); err == nil {
+		t.Errorf("expected error for unknown archive")
+	}
+}
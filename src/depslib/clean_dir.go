@@ -6,10 +6,13 @@
 package depslib
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func TempDirectory(tempSuffix string) (string, error) {
@@ -20,17 +23,177 @@ func TempDirectory(tempSuffix string) (string, error) {
 	return dir, err
 }
 
-func BackupDeps(depsPath string) error {
-	log.Println("force clean deps")
-	_, statErr := os.Stat(depsPath)
-	if statErr == nil {
-		_, cleanErr := CleanDirectoryWithBackup(depsPath, "deps.clean")
-		if cleanErr != nil {
-			return cleanErr
+const (
+	depsStagingSuffix = ".staging"
+	depsBackupSuffix  = ".backup"
+	depsCleanSuffix   = ".clean"
+)
+
+// StagedDeps is a directory next to deps/ that the dependencies are fetched into. It replaces deps/ only when
+// Commit is called, so a failed fetch leaves the previous deps/ untouched.
+type StagedDeps struct {
+	DepsPath    string
+	StagingPath string
+}
+
+func siblingDirectories(depsPath string, suffix string) ([]string, error) {
+	return filepath.Glob(filepath.Join(filepath.Dir(depsPath), filepath.Base(depsPath)+suffix+"*"))
+}
+
+// recoverDeps restores deps/ from a backup left by an interrupted Commit, and removes
+// staging and backup directories from earlier runs.
+func recoverDeps(depsPath string) error {
+	backupDirectories, backupErr := siblingDirectories(depsPath, depsBackupSuffix)
+	if backupErr != nil {
+		return backupErr
+	}
+	if !directoryExists(depsPath) {
+		for _, backupDirectory := range backupDirectories {
+			backupDeps := filepath.Join(backupDirectory, "deps")
+			if !directoryExists(backupDeps) {
+				continue
+			}
+			log.Printf("restoring '%v' from '%v'\n", depsPath, backupDeps)
+			if err := os.Rename(backupDeps, depsPath); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	var leftovers []string
+	for _, suffix := range []string{depsStagingSuffix, depsBackupSuffix, depsCleanSuffix} {
+		directories, globErr := siblingDirectories(depsPath, suffix)
+		if globErr != nil {
+			return globErr
+		}
+		leftovers = append(leftovers, directories...)
+	}
+	for _, leftover := range leftovers {
+		log.Printf("removing leftover '%v'\n", leftover)
+		if err := os.RemoveAll(leftover); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// StageDeps creates an empty staging directory in the same directory as deps/, so that it can be renamed into place.
+func StageDeps(depsPath string) (*StagedDeps, error) {
+	depsPath = filepath.Clean(depsPath)
+	if err := os.MkdirAll(filepath.Dir(depsPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := recoverDeps(depsPath); err != nil {
+		return nil, err
+	}
+
+	stagingPath, tempErr := ioutil.TempDir(filepath.Dir(depsPath), filepath.Base(depsPath)+depsStagingSuffix)
+	if tempErr != nil {
+		return nil, tempErr
+	}
+
+	return &StagedDeps{DepsPath: depsPath, StagingPath: stagingPath}, nil
+}
+
+// FinalPath returns where a file in the staging directory ends up when the staging directory is committed.
+// Other filenames, and all filenames when nothing is staged, are returned as they are.
+func (s *StagedDeps) FinalPath(filename string) string {
+	if s == nil {
+		return filename
+	}
+	relative, relErr := filepath.Rel(s.StagingPath, filename)
+	if relErr != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return filename
+	}
+	return filepath.Join(s.DepsPath, relative)
+}
+
+func copyFile(sourceFilename string, targetFilename string, mode os.FileMode) error {
+	source, openErr := os.Open(sourceFilename)
+	if openErr != nil {
+		return openErr
+	}
+	defer source.Close()
+
+	target, createErr := os.OpenFile(targetFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if createErr != nil {
+		return createErr
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+// Reuse hard links (or copies, if linking fails) an unchanged package directory from deps/ into the staging
+// directory, instead of extracting it again. deps/ itself is not changed before Commit.
+func (s *StagedDeps) Reuse(previousDirectory string, stagedDirectory string) error {
+	log.Printf("'%v' is unchanged, reusing it\n", previousDirectory)
+	return filepath.Walk(previousDirectory, func(sourcePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, relErr := filepath.Rel(previousDirectory, sourcePath)
+		if relErr != nil {
+			return relErr
+		}
+		targetPath := filepath.Join(stagedDirectory, relativePath)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(targetPath, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, readErr := os.Readlink(sourcePath)
+			if readErr != nil {
+				return readErr
+			}
+			return os.Symlink(linkTarget, targetPath)
+		default:
+			if err := os.Link(sourcePath, targetPath); err == nil {
+				return nil
+			}
+			return copyFile(sourcePath, targetPath, info.Mode().Perm())
 		}
-	} else {
-		log.Printf("deps path do not exists %v\n", depsPath)
-		return nil
+	})
+}
+
+// Discard removes the staging directory and keeps deps/ as it was.
+func (s *StagedDeps) Discard() error {
+	log.Printf("keeping '%v', removing '%v'\n", s.DepsPath, s.StagingPath)
+	return os.RemoveAll(s.StagingPath)
+}
+
+// Commit replaces deps/ with the staging directory. The previous deps/ is moved to a backup directory first,
+// which is put back if the staging directory can not be moved into place, and removed if it could.
+func (s *StagedDeps) Commit() error {
+	var backupDirectory string
+	if directoryExists(s.DepsPath) {
+		var tempErr error
+		backupDirectory, tempErr = ioutil.TempDir(filepath.Dir(s.DepsPath), filepath.Base(s.DepsPath)+depsBackupSuffix)
+		if tempErr != nil {
+			return tempErr
+		}
+		if err := os.Rename(s.DepsPath, filepath.Join(backupDirectory, "deps")); err != nil {
+			os.RemoveAll(backupDirectory)
+			return err
+		}
+	}
+
+	if err := os.Rename(s.StagingPath, s.DepsPath); err != nil {
+		if backupDirectory != "" {
+			if restoreErr := os.Rename(filepath.Join(backupDirectory, "deps"), s.DepsPath); restoreErr != nil {
+				return fmt.Errorf("could not move '%v' into place (%v) and could not restore it from '%v': %w", s.StagingPath, err, backupDirectory, restoreErr)
+			}
+			os.RemoveAll(backupDirectory)
+		}
+		return err
+	}
+
+	if backupDirectory != "" {
+		return os.RemoveAll(backupDirectory)
 	}
 
 	return nil
@@ -0,0 +1,118 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, filename string, content string) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestFile(t *testing.T, filename string, expected string) {
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil || string(content) != expected {
+		t.Errorf("expected '%v' in '%v', got '%s' %v", expected, filename, content, readErr)
+	}
+}
+
+func TestStagedDepsCommit(t *testing.T) {
+	directory := t.TempDir()
+	depsPath := filepath.Join(directory, "deps")
+	writeTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "old")
+
+	staged, stageErr := StageDeps(depsPath)
+	if stageErr != nil {
+		t.Fatal(stageErr)
+	}
+	writeTestFile(t, filepath.Join(staged.StagingPath, "piot/thunder/deps.toml"), "new")
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "old")
+
+	if err := staged.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "new")
+
+	leftovers, _ := filepath.Glob(filepath.Join(directory, "deps.[bcs]*"))
+	if len(leftovers) != 0 {
+		t.Errorf("backup should be removed after commit %v", leftovers)
+	}
+}
+
+func TestStagedDepsDiscard(t *testing.T) {
+	directory := t.TempDir()
+	depsPath := filepath.Join(directory, "deps")
+	writeTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "old")
+
+	staged, stageErr := StageDeps(depsPath)
+	if stageErr != nil {
+		t.Fatal(stageErr)
+	}
+	writeTestFile(t, filepath.Join(staged.StagingPath, "piot/thunder/deps.toml"), "half")
+	if err := staged.Discard(); err != nil {
+		t.Fatal(err)
+	}
+
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "old")
+	if directoryExists(staged.StagingPath) {
+		t.Errorf("staging directory should be removed")
+	}
+}
+
+func TestStagedDepsReuseKeepsDeps(t *testing.T) {
+	directory := t.TempDir()
+	depsPath := filepath.Join(directory, "deps")
+	writeTestFile(t, filepath.Join(depsPath, "piot/thunder/src/thunder.c"), "int x;")
+	if err := os.Symlink("src", filepath.Join(depsPath, "piot/thunder/include")); err != nil {
+		t.Fatal(err)
+	}
+
+	staged, stageErr := StageDeps(depsPath)
+	if stageErr != nil {
+		t.Fatal(stageErr)
+	}
+	if err := staged.Reuse(filepath.Join(depsPath, "piot/thunder"), filepath.Join(staged.StagingPath, "piot/thunder")); err != nil {
+		t.Fatal(err)
+	}
+
+	// deps/ must be complete if the process stops before Commit
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/src/thunder.c"), "int x;")
+	checkTestFile(t, filepath.Join(staged.StagingPath, "piot/thunder/include/thunder.c"), "int x;")
+
+	if err := staged.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/include/thunder.c"), "int x;")
+}
+
+func TestStageDepsRecoversInterruptedCommit(t *testing.T) {
+	directory := t.TempDir()
+	depsPath := filepath.Join(directory, "deps")
+	writeTestFile(t, filepath.Join(directory, "deps.backup123/deps/piot/thunder/deps.toml"), "old")
+	writeTestFile(t, filepath.Join(directory, "deps.staging456/piot/thunder/deps.toml"), "half")
+	writeTestFile(t, filepath.Join(directory, "deps.clean789/deps.clean/deps.toml"), "older")
+
+	staged, stageErr := StageDeps(depsPath)
+	if stageErr != nil {
+		t.Fatal(stageErr)
+	}
+	defer staged.Discard()
+
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "old")
+	leftovers, _ := filepath.Glob(filepath.Join(directory, "deps.[bcs]*"))
+	if len(leftovers) != 1 || leftovers[0] != staged.StagingPath {
+		t.Errorf("only the new staging directory should be left %v", leftovers)
+	}
+}
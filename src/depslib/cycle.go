@@ -0,0 +1,91 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CycleError is returned when packages depend on each other. The first and last node in Path are the same.
+type CycleError struct {
+	Path []*DependencyNode
+}
+
+func (e *CycleError) Error() string {
+	var names []string
+	for _, node := range e.Path {
+		names = append(names, node.name)
+	}
+
+	lines := []string{fmt.Sprintf("dependency cycle: %v", strings.Join(names, " -> "))}
+	for index := 0; index < len(e.Path)-1; index++ {
+		from := e.Path[index]
+		lines = append(lines, fmt.Sprintf("  '%v' -> '%v' declared in '%v'", from.name, e.Path[index+1].name, from.configFilename))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type visitState uint8
+
+const (
+	notVisited visitState = iota
+	visiting
+	visited
+)
+
+func findCycleFrom(node *DependencyNode, states map[*DependencyNode]visitState, stack []*DependencyNode) []*DependencyNode {
+	states[node] = visiting
+	stack = append(stack, node)
+
+	for _, dependency := range node.dependencies {
+		switch states[dependency] {
+		case visiting:
+			for index, stackNode := range stack {
+				if stackNode == dependency {
+					return append(append([]*DependencyNode{}, stack[index:]...), dependency)
+				}
+			}
+		case notVisited:
+			if cycle := findCycleFrom(dependency, states, stack); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	states[node] = visited
+
+	return nil
+}
+
+// checkForCycles walks the complete graph, since parallel fetching can close a cycle from two different paths.
+func checkForCycles(cache *Cache, rootNode *DependencyNode) error {
+	states := make(map[*DependencyNode]visitState)
+
+	var names []string
+	for name := range cache.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	startNodes := []*DependencyNode{rootNode}
+	for _, name := range names {
+		startNodes = append(startNodes, cache.Nodes[name])
+	}
+
+	for _, node := range startNodes {
+		if states[node] != notVisited {
+			continue
+		}
+		if cycle := findCycleFrom(node, states, nil); cycle != nil {
+			return &CycleError{Path: cycle}
+		}
+	}
+
+	return nil
+}
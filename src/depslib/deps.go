@@ -6,14 +6,18 @@
 package depslib
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/url"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/blang/semver"
 )
@@ -45,63 +49,94 @@ func symlinkRepo(rootPath string, depsPath string, repoName string) error {
 	//return symlinkSrcInclude(packageDir, depsPath, shortName)
 }
 
-func wgetRepo(rootPath string, depsPath string, repoName string) error {
-	downloadURLString := fmt.Sprintf("https://%vgithub.com/%v/archive/main.zip", gitRepoPrefix(), repoName)
-	fmt.Printf("downloading from '%v'\n", downloadURLString)
-	downloadURL, parseErr := url.Parse(downloadURLString)
-	if parseErr != nil {
-		return parseErr
-	}
+type revision struct {
+	commit   string
+	checksum string
+}
 
-	downloadErr := HTTPGet(downloadURL, "temp.zip")
-	if downloadErr != nil {
-		return downloadErr
+func fileChecksum(filename string) (string, error) {
+	file, openErr := os.Open(filename)
+	if openErr != nil {
+		return "", openErr
 	}
+	defer file.Close()
 
-	shortName := RepoNameToShortName(repoName)
-	lastName := strings.Split(shortName, "/")[1]
-	targetDirectory := path.Join(depsPath, shortName)
-	zipPrefix := fmt.Sprintf("%v-main/", lastName)
-
-	unzipErr := unzipFile("temp.zip", targetDirectory, zipPrefix)
-	if unzipErr != nil {
-		log.Printf("unzipErr:%v", unzipErr)
-		return unzipErr
+	hash := sha256.New()
+	if _, copyErr := io.Copy(hash, file); copyErr != nil {
+		return "", copyErr
 	}
-	return nil
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func gitClone(depsPath string, repoName string, shortName string) error {
-	downloadURLString := fmt.Sprintf("https://%vgithub.com/%v.git", gitRepoPrefix(), repoName)
-	downloadURL, parseErr := url.Parse(downloadURLString)
-	if parseErr != nil {
-		return parseErr
-	}
-
-	fmt.Printf("git clone from '%v' to %v\n", downloadURL, shortName)
-
-	cmd := exec.Command("git", "clone", downloadURL.String(), shortName)
-
-	cmd.Dir = depsPath
+// fetchTarget is everything that is needed to fetch a single package.
+type fetchTarget struct {
+	name              string
+	source            Source
+	ref               Ref
+	locked            *LockedPackage
+	expectedChecksum  string
+	checksumPinned    bool
+	stripPrefix       string
+	previous          *PackageState
+	previousDirectory string
+	staged            *StagedDeps
+	git               GitOptions
+}
 
-	cmd.Start()
+func wgetRepo(rootPath string, depsPath string, target fetchTarget, downloads *DownloadCache) (revision, error) {
+	repoName := target.name
+	locked := target.locked
 
-	cmd.Wait()
+	shortName := RepoNameToShortName(repoName)
+	targetDirectory := path.Join(depsPath, shortName)
+	canReuse := target.staged != nil && directoryExists(target.previousDirectory)
+	if canReuse && isPinnedAndUnchanged(target.previous, target) {
+		fetched := revision{commit: target.previous.Commit, checksum: target.previous.Checksum}
+		return fetched, target.staged.Reuse(target.previousDirectory, targetDirectory)
+	}
 
-	return nil
-}
+	// the locked checksum is for the archive of the ref, archives downloaded by commit have other content
+	archiveRef := target.ref
+	expectedChecksum := target.expectedChecksum
+	archiveFilename, entry, fetchErr := downloads.Fetch(repoName, target.source, archiveRef, expectedChecksum)
+	if errors.Is(fetchErr, errChecksumMismatch) && locked != nil && locked.Commit != "" && !target.checksumPinned && !isImmutableRef(target.ref) {
+		log.Printf("'%v' has moved since it was locked, fetching locked commit %v", archiveRef, locked.Commit)
+		archiveRef = Ref{Kind: Revision, Name: locked.Commit}
+		expectedChecksum = ""
+		archiveFilename, entry, fetchErr = downloads.Fetch(repoName, target.source, archiveRef, expectedChecksum)
+	}
+	if fetchErr != nil {
+		return revision{}, fetchErr
+	}
 
-func gitPull(targetDirectory string, repoName string) error {
-	fmt.Printf("git pull %v %v\n", repoName, targetDirectory)
-	cmd := exec.Command("git", "pull")
+	if expectedChecksum != "" && expectedChecksum != entry.Checksum {
+		return revision{}, fmt.Errorf("checksum mismatch for '%v' at '%v': expected %v, got %v", repoName, archiveRef, expectedChecksum, entry.Checksum)
+	}
 
-	cmd.Dir = targetDirectory
+	if locked != nil && locked.Commit != "" && entry.Commit != locked.Commit {
+		return revision{}, fmt.Errorf("'%v' archive at '%v' is for commit %v, but %v is locked", repoName, archiveRef, entry.Commit, locked.Commit)
+	}
 
-	cmd.Start()
+	fetched := revision{commit: entry.Commit, checksum: entry.Checksum}
+	if canReuse && isUnchanged(target.previous, entry.Checksum, target.stripPrefix) {
+		return fetched, target.staged.Reuse(target.previousDirectory, targetDirectory)
+	}
 
-	cmd.Wait()
+	archivePrefix := target.stripPrefix
+	if archivePrefix == "" {
+		var prefixErr error
+		archivePrefix, prefixErr = archiveTopDirectory(archiveFilename)
+		if prefixErr != nil {
+			return revision{}, prefixErr
+		}
+	}
 
-	return nil
+	extractErr := extractArchive(archiveFilename, targetDirectory, archivePrefix)
+	if extractErr != nil {
+		log.Printf("extractErr:%v", extractErr)
+		return revision{}, extractErr
+	}
+	return fetched, nil
 }
 
 func gitRepoPrefix() string {
@@ -110,7 +145,7 @@ func gitRepoPrefix() string {
 		return ""
 	}
 
-	fmt.Printf("found secret GITHUB_TOKEN\n")
+	log.Printf("found secret GITHUB_TOKEN\n")
 
 	return fmt.Sprintf("%v@", token)
 }
@@ -121,19 +156,11 @@ func directoryExists(directory string) bool {
 	return checkDirectoryErr == nil && stat.IsDir()
 }
 
-func cloneOrPullRepo(targetDirectory string, depsPath string, repoName string, shortName string) error {
-	checkDirectory := path.Join(targetDirectory, ".git")
-	if directoryExists(checkDirectory) {
-		return gitPull(targetDirectory, repoName)
-	}
-	return gitClone(depsPath, repoName, shortName)
-
-}
-
-func copyDependency(rootPath string, depsPath string, repoName string, mode Mode) error {
+func copyDependency(rootPath string, depsPath string, target fetchTarget, mode Mode, downloads *DownloadCache) (revision, error) {
+	repoName := target.name
 	shortName := RepoNameToShortName(repoName)
 	targetDirectory := path.Join(depsPath, shortName)
-	fmt.Printf("copy from '%v' to '%v'\n", shortName, targetDirectory)
+	log.Printf("copy from '%v' to '%v'\n", shortName, targetDirectory)
 
 	if mode != Symlink {
 		os.MkdirAll(targetDirectory, 0755)
@@ -142,50 +169,52 @@ func copyDependency(rootPath string, depsPath string, repoName string, mode Mode
 	}
 	switch mode {
 	case Symlink:
-		return symlinkRepo(rootPath, depsPath, repoName)
+		return revision{}, symlinkRepo(rootPath, depsPath, repoName)
 	case Clone:
-		return cloneOrPullRepo(targetDirectory, depsPath, repoName, shortName)
+		return cloneOrPullRepo(targetDirectory, depsPath, shortName, target)
 	case Wget:
-		return wgetRepo(rootPath, depsPath, repoName)
+		return wgetRepo(rootPath, depsPath, target, downloads)
 	default:
-		return fmt.Errorf("unknown mode")
+		return revision{}, fmt.Errorf("unknown mode")
 	}
 }
 
-func copyOrGetConfigDirectory(rootPath string, depsPath string, repoName string, mode Mode) (string, error) {
+func copyOrGetConfigDirectory(rootPath string, depsPath string, target fetchTarget, mode Mode, downloads *DownloadCache) (string, revision, error) {
+	repoName := target.name
 	switch mode {
 	case ReadLocal:
 		shortName := RepoNameToShortName(repoName)
 		packageDir := path.Join(rootPath, shortName+"/")
-		return packageDir, nil
+		return packageDir, revision{}, nil
 	default:
 		directoryName := RepoNameToShortName(repoName)
 		packageDirectory := path.Join(depsPath, directoryName)
-		if err := copyDependency(rootPath, depsPath, repoName, mode); err != nil {
-			return "", err
+		fetched, err := copyDependency(rootPath, depsPath, target, mode, downloads)
+		if err != nil {
+			return "", revision{}, err
 		}
 		if mode == Symlink {
 			shortName := RepoNameToShortName(repoName)
 			packageDirectory = path.Join(rootPath, shortName+"/")
 		}
-		return packageDirectory, nil
+		return packageDirectory, fetched, nil
 	}
 }
 
-func establishPackageAndReadConfig(rootPath string, depsPath string, packageName string, mode Mode) (*Config, error) {
-	configDirectory, copyErr := copyOrGetConfigDirectory(rootPath, depsPath, packageName, mode)
+func establishPackageAndReadConfig(rootPath string, depsPath string, target fetchTarget, mode Mode, downloads *DownloadCache) (*Config, revision, error) {
+	configDirectory, fetched, copyErr := copyOrGetConfigDirectory(rootPath, depsPath, target, mode, downloads)
 	if copyErr != nil {
-		return nil, copyErr
+		return nil, revision{}, copyErr
 	}
 
 	conf, confErr := ReadConfigFromDirectory(configDirectory)
 	if confErr != nil {
-		return nil, confErr
+		return nil, revision{}, confErr
 	}
-	if conf.Name != packageName {
-		return nil, fmt.Errorf("name mismatch %v vs %v", conf.Name, packageName)
+	if conf.Name != target.name {
+		return nil, revision{}, fmt.Errorf("name mismatch %v vs %v", conf.Name, target.name)
 	}
-	return conf, confErr
+	return conf, fetched, confErr
 }
 
 type DependencyNode struct {
@@ -196,6 +225,14 @@ type DependencyNode struct {
 	dependencies    []*DependencyNode
 	development     []*DependencyNode
 	dependingOnThis []*DependencyNode
+	source          string
+	ref             Ref
+	commit          string
+	checksum        string
+	stripPrefix     string
+	configFilename  string
+	path            string
+	local           bool
 }
 
 func (n *DependencyNode) Name() string {
@@ -210,6 +247,27 @@ func (n *DependencyNode) ArtifactType() ArtifactType {
 	return n.artifactType
 }
 
+func (n *DependencyNode) Version() semver.Version {
+	return n.version
+}
+
+func (n *DependencyNode) Source() string {
+	return n.source
+}
+
+func (n *DependencyNode) Ref() Ref {
+	return n.ref
+}
+
+// Path is the local directory of a path dependency, or empty if the package is fetched.
+func (n *DependencyNode) Path() string {
+	return n.path
+}
+
+func (n *DependencyNode) Commit() string {
+	return n.commit
+}
+
 func (n *DependencyNode) ShortName() string {
 	return RepoNameToShortName(n.name)
 }
@@ -227,8 +285,15 @@ func (n *DependencyNode) AddDependency(node *DependencyNode) {
 	node.AddDependingOnThis(n)
 }
 
+func (n *DependencyNode) Development() []*DependencyNode {
+	return n.development
+}
+
+// AddDevelopment adds a development dependency. It is a separate kind of edge, that is only followed when
+// building tests.
 func (n *DependencyNode) AddDevelopment(node *DependencyNode) {
 	n.development = append(n.development, node)
+	node.AddDependingOnThis(n)
 }
 
 func (n *DependencyNode) String() string {
@@ -236,52 +301,311 @@ func (n *DependencyNode) String() string {
 }
 
 func (n *DependencyNode) Print(indent int) {
+	n.print(indent, "")
+}
+
+func (n *DependencyNode) print(indent int, suffix string) {
 	indentString := strings.Repeat("..", indent)
-	fmt.Printf("%s %v\n", indentString, n)
+	fmt.Printf("%s %v%v\n", indentString, n, suffix)
 
 	for _, depNode := range n.dependencies {
-		depNode.Print(indent + 1)
+		depNode.print(indent+1, "")
+	}
+	for _, depNode := range n.development {
+		depNode.print(indent+1, " (dev)")
 	}
 }
 
 type DependencyInfo struct {
 	RootPath        string
 	PackageRootPath string
+	DepsPath        string
+	Features        []string
+	TargetOS        string
 	RootNodes       []*DependencyNode
-	RootNode        *DependencyNode
+	// DevelopmentNodes are only needed by the development dependencies of the root, and are not in RootNodes.
+	DevelopmentNodes []*DependencyNode
+	RootNode         *DependencyNode
 }
 
-type Cache struct {
-	Nodes map[string]*DependencyNode
+// pendingNode is a package that is being fetched. ready is closed when the node, or the error, is known.
+type pendingNode struct {
+	node  *DependencyNode
+	err   error
+	ready chan struct{}
 }
 
-func NewCache() *Cache {
-	return &Cache{Nodes: make(map[string]*DependencyNode)}
+func (p *pendingNode) done(node *DependencyNode, err error) {
+	p.node = node
+	p.err = err
+	close(p.ready)
+}
+
+// Cache holds the resolved nodes. It is safe for concurrent use, and at most jobs packages are fetched at the same time.
+type Cache struct {
+	Nodes        map[string]*DependencyNode
+	Requirements map[string][]VersionRequirement
+	Lock         *LockFile
+	Downloads    *DownloadCache
+	RootSource   string
+	// PreviousState and PreviousDepsPath describe the deps/ from the last fetch, so unchanged packages can be reused.
+	PreviousState    *DepsState
+	PreviousDepsPath string
+	// Staged is set when packages are fetched into a staging directory instead of deps/.
+	Staged    *StagedDeps
+	Git       GitOptions
+	Overrides *Overrides
+	TargetOS  string
+	mutex     sync.Mutex
+	pending   map[string]*pendingNode
+	jobs      chan struct{}
+}
+
+const DefaultJobCount = 4
+
+func NewCache(lockFile *LockFile, downloads *DownloadCache, jobCount int) *Cache {
+	if jobCount <= 0 {
+		jobCount = DefaultJobCount
+	}
+	return &Cache{Nodes: make(map[string]*DependencyNode), Requirements: make(map[string][]VersionRequirement), Lock: lockFile,
+		Downloads: downloads, TargetOS: DefaultTargetOS(), pending: make(map[string]*pendingNode), jobs: make(chan struct{}, jobCount)}
 }
 
 func (c *Cache) FindNode(name string) *DependencyNode {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return c.Nodes[name]
 }
+
 func (c *Cache) AddNode(name string, node *DependencyNode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	c.Nodes[name] = node
 }
 
-func handleNode(rootPath string, depsPath string, node *DependencyNode, cache *Cache, depName string, mode Mode, useDevelopmentDependencies bool) (*DependencyNode, error) {
-	foundNode := cache.FindNode(depName)
-	if foundNode == nil {
-		depConf, confErr := establishPackageAndReadConfig(rootPath, depsPath, depName, mode)
-		if confErr != nil {
-			return nil, confErr
+func (c *Cache) AddRequirement(name string, requirement VersionRequirement) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Requirements[name] = append(c.Requirements[name], requirement)
+}
+
+func (c *Cache) addDependency(node *DependencyNode, dependency *DependencyNode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	node.AddDependency(dependency)
+}
+
+func (c *Cache) addDevelopment(node *DependencyNode, dependency *DependencyNode) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	node.AddDevelopment(dependency)
+}
+
+// startFetch returns the pending node for the package, and true if the caller is the one that should fetch it.
+func (c *Cache) startFetch(name string) (*pendingNode, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if pending, wasFound := c.pending[name]; wasFound {
+		return pending, false
+	}
+	pending := &pendingNode{ready: make(chan struct{})}
+	c.pending[name] = pending
+	if node, wasFound := c.Nodes[name]; wasFound {
+		pending.done(node, nil)
+		return pending, false
+	}
+	return pending, true
+}
+
+func (c *Cache) acquireJob() {
+	c.jobs <- struct{}{}
+}
+
+func (c *Cache) releaseJob() {
+	<-c.jobs
+}
+
+func (c *Cache) CheckRequirements() error {
+	var names []string
+	for name := range c.Requirements {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := c.FindNode(name)
+		if node == nil {
+			continue
+		}
+		if err := checkVersionRequirements(name, node.version, c.Requirements[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func lockedPackageForRef(lockFile *LockFile, name string, ref Ref) *LockedPackage {
+	locked := lockFile.Find(name)
+	if locked != nil && locked.Ref != ref.String() {
+		log.Printf("'%v' is locked at '%v' but deps.toml asks for '%v', ignoring lock", name, locked.Ref, ref)
+		return nil
+	}
+	return locked
+}
+
+// effectiveSource is the source of the dependency itself, or the global source of the deps.toml that declares it,
+// or the global source of the root deps.toml.
+func effectiveSource(dep Package, conf *Config, cache *Cache) string {
+	if dep.Source != "" {
+		return dep.Source
+	}
+	if conf.Source != "" {
+		return conf.Source
+	}
+	return cache.RootSource
+}
+
+func sameDirectory(a string, b string) bool {
+	absoluteA, errA := filepath.Abs(a)
+	absoluteB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absoluteA == absoluteB
+}
+
+// effectivePath makes the path of a path dependency relative to the deps.toml that declares it.
+func effectivePath(dep Package, conf *Config) string {
+	if dep.Path == "" || filepath.IsAbs(dep.Path) || conf.filename == "" {
+		return dep.Path
+	}
+	return filepath.Join(filepath.Dir(conf.filename), dep.Path)
+}
+
+// fetchPathNode reads a path dependency from its directory and, unless only reading locally, links its src/ into
+// deps/ like Symlink mode does.
+func fetchPathNode(depsPath string, dep Package, mode Mode) (*DependencyNode, *Config, error) {
+	packageDirectory, absErr := filepath.Abs(dep.Path)
+	if absErr != nil {
+		return nil, nil, absErr
+	}
+
+	depConf, confErr := ReadConfigFromDirectory(packageDirectory)
+	if confErr != nil {
+		return nil, nil, fmt.Errorf("path dependency '%v': %w", dep.Name, confErr)
+	}
+	if depConf.Name != dep.Name {
+		return nil, nil, fmt.Errorf("path dependency '%v' in '%v' is named '%v'", dep.Name, packageDirectory, depConf.Name)
+	}
+
+	sourceDirectory := filepath.Join(packageDirectory, "src")
+	if mode != ReadLocal && directoryExists(sourceDirectory) {
+		linkName := filepath.Join(depsPath, RepoNameToShortName(dep.Name), "src")
+		log.Printf("symlink path dependency '%v' to '%v'\n", sourceDirectory, linkName)
+		if err := MakeSymlink(sourceDirectory, linkName); err != nil {
+			return nil, nil, err
 		}
+	}
 
-		var convertErr error
-		foundNode, convertErr = convertFromConfigNode(rootPath, depsPath, depConf, cache, mode, useDevelopmentDependencies)
-		if convertErr != nil {
-			return nil, convertErr
+	foundNode, nodeErr := newDependencyNode(depConf)
+	if nodeErr != nil {
+		return nil, nil, nodeErr
+	}
+	foundNode.path = packageDirectory
+	foundNode.local = true
+
+	return foundNode, depConf, nil
+}
+
+func fetchNode(rootPath string, depsPath string, node *DependencyNode, cache *Cache, dep Package, mode Mode) (*DependencyNode, *Config, error) {
+	if dep.Path != "" {
+		return fetchPathNode(depsPath, dep, mode)
+	}
+
+	depName := dep.Name
+	ref := dep.Ref()
+	source, sourceErr := ParseSource(dep.Source)
+	if sourceErr != nil {
+		return nil, nil, fmt.Errorf("'%v' dependency '%v': %w", node.name, depName, sourceErr)
+	}
+
+	locked := lockedPackageForRef(cache.Lock, depName, ref)
+	expectedChecksum := ""
+	if locked != nil {
+		expectedChecksum = locked.Checksum
+	}
+	if dep.Sha256 != "" {
+		if locked != nil && locked.Checksum != dep.Sha256 {
+			log.Printf("'%v' has sha256 %v in deps.toml but %v in deps.lock, ignoring lock", depName, dep.Sha256, locked.Checksum)
+			locked = nil
 		}
-	} else {
+		expectedChecksum = dep.Sha256
+		if mode == Clone {
+			log.Printf("sha256 of '%v' is only verified in wget mode", depName)
+		}
+	}
 
+	previous := cache.PreviousState.Find(depName)
+	if previous != nil && previous.Source != dep.Source {
+		previous = nil
+	}
+	target := fetchTarget{name: depName, source: source, ref: ref, locked: locked, expectedChecksum: expectedChecksum,
+		checksumPinned: dep.Sha256 != "", stripPrefix: dep.StripPrefix, previous: previous, staged: cache.Staged, git: cache.Git}
+	if cache.PreviousDepsPath != "" {
+		target.previousDirectory = path.Join(cache.PreviousDepsPath, RepoNameToShortName(depName))
 	}
+
+	cache.acquireJob()
+	depConf, fetched, confErr := establishPackageAndReadConfig(rootPath, depsPath, target, mode, cache.Downloads)
+	cache.releaseJob()
+	if confErr != nil {
+		return nil, nil, confErr
+	}
+
+	foundNode, nodeErr := newDependencyNode(depConf)
+	if nodeErr != nil {
+		return nil, nil, nodeErr
+	}
+	foundNode.ref = ref
+	foundNode.source = dep.Source
+	foundNode.commit = fetched.commit
+	foundNode.checksum = fetched.checksum
+	foundNode.stripPrefix = dep.StripPrefix
+	// the staging directory is removed if the fetch fails, so errors must refer to deps/
+	foundNode.configFilename = cache.Staged.FinalPath(depConf.filename)
+
+	return foundNode, depConf, nil
+}
+
+func handleNode(rootPath string, depsPath string, node *DependencyNode, cache *Cache, dep Package, mode Mode) (*DependencyNode, error) {
+	pending, shouldFetch := cache.startFetch(dep.Name)
+	if !shouldFetch {
+		<-pending.ready
+		if pending.err != nil {
+			return nil, pending.err
+		}
+		foundNode := pending.node
+		if dep.Path != "" && !sameDirectory(dep.Path, foundNode.path) {
+			return nil, fmt.Errorf("'%v' wants '%v' from path '%v', but it is already used from '%v'", node.name, dep.Name, dep.Path, sourceName(foundNode))
+		}
+		ref := dep.Ref()
+		if !ref.IsDefault() && !foundNode.ref.IsDefault() && ref != foundNode.ref {
+			return nil, fmt.Errorf("'%v' wants '%v' at '%v', but it is already fetched at '%v'", node.name, dep.Name, ref, foundNode.ref)
+		}
+		return foundNode, nil
+	}
+
+	foundNode, depConf, fetchErr := fetchNode(rootPath, depsPath, node, cache, dep, mode)
+	if fetchErr != nil {
+		pending.done(nil, fetchErr)
+		return nil, fetchErr
+	}
+	cache.AddNode(dep.Name, foundNode)
+	pending.done(foundNode, nil)
+
+	// Development dependencies are only used for the package that is being worked on, not for its dependencies.
+	if err := resolveDependencies(rootPath, depsPath, foundNode, depConf, cache, mode, false); err != nil {
+		return nil, err
+	}
+
 	return foundNode, nil
 }
 
@@ -307,34 +631,129 @@ func ToArtifactType(v string) ArtifactType {
 	return Library
 }
 
-func convertFromConfigNode(rootPath string, depsPath string, conf *Config, cache *Cache, mode Mode, useDevelopmentDependencies bool) (*DependencyNode, error) {
+func (t ArtifactType) String() string {
+	switch t {
+	case Library:
+		return "lib"
+	case ConsoleApplication:
+		return "console"
+	case Application:
+		return "executable"
+	case Inherit:
+		return "inherit"
+	}
+	return "unknown"
+}
+
+func addRequirement(cache *Cache, requiredBy string, dep Package) error {
+	constraint, constraintErr := ParseVersionConstraint(dep.Version)
+	if constraintErr != nil {
+		return fmt.Errorf("'%v' dependency '%v': %w", requiredBy, dep.Name, constraintErr)
+	}
+	cache.AddRequirement(dep.Name, VersionRequirement{RequiredBy: requiredBy, Constraint: constraint})
+	return nil
+}
+
+func newDependencyNode(conf *Config) (*DependencyNode, error) {
 	artifactType := ToArtifactType(conf.ArtifactType)
-	node := &DependencyNode{name: conf.Name, libraryName: conf.LibraryName, version: semver.MustParse(conf.Version), artifactType: artifactType}
-	cache.AddNode(conf.Name, node)
-	for _, dep := range conf.Dependencies {
-		foundNode, handleErr := handleNode(rootPath, depsPath, node, cache, dep.Name, mode, useDevelopmentDependencies)
-		if handleErr != nil {
-			return nil, handleErr
+	version, versionErr := semver.Parse(conf.Version)
+	if versionErr != nil {
+		return nil, fmt.Errorf("'%v' has illegal version '%v': %w", conf.Name, conf.Version, versionErr)
+	}
+	return &DependencyNode{name: conf.Name, libraryName: conf.LibraryName, version: version, artifactType: artifactType,
+		configFilename: conf.filename}, nil
+}
+
+// handleNodes fetches the packages at the same time and returns the nodes in the same order as the packages.
+func handleNodes(rootPath string, depsPath string, node *DependencyNode, conf *Config, cache *Cache, deps []Package, mode Mode) ([]*DependencyNode, error) {
+	foundNodes := make([]*DependencyNode, len(deps))
+	errs := make([]error, len(deps))
+
+	// all requirements are checked before fetching, so no fetch is left running when one of them is illegal
+	effectiveDeps := make([]Package, len(deps))
+	for index, dep := range deps {
+		dep.Source = effectiveSource(dep, conf, cache)
+		dep.Path = effectivePath(dep, conf)
+		dep = cache.Overrides.apply(dep)
+		if err := addRequirement(cache, conf.Name, dep); err != nil {
+			return nil, err
+		}
+		effectiveDeps[index] = dep
+	}
+
+	var waitGroup sync.WaitGroup
+	for index, dep := range effectiveDeps {
+		waitGroup.Add(1)
+		go func(index int, dep Package) {
+			defer waitGroup.Done()
+			foundNodes[index], errs[index] = handleNode(rootPath, depsPath, node, cache, dep, mode)
+		}(index, dep)
+	}
+	waitGroup.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
-		node.AddDependency(foundNode)
 	}
+
+	return foundNodes, nil
+}
+
+func resolveDependencies(rootPath string, depsPath string, node *DependencyNode, conf *Config, cache *Cache, mode Mode, useDevelopmentDependencies bool) error {
+	foundNodes, handleErr := handleNodes(rootPath, depsPath, node, conf, cache, conf.dependenciesForTarget(cache.TargetOS), mode)
+	if handleErr != nil {
+		return handleErr
+	}
+	for _, foundNode := range foundNodes {
+		cache.addDependency(node, foundNode)
+	}
+
 	if useDevelopmentDependencies {
-		for _, dep := range conf.Development {
-			_, handleErr := handleNode(rootPath, depsPath, node, cache, dep.Name, mode, useDevelopmentDependencies)
-			if handleErr != nil {
-				return nil, handleErr
-			}
-			//node.AddDevelopment(foundNode)
+		developmentNodes, handleErr := handleNodes(rootPath, depsPath, node, conf, cache, conf.Development, mode)
+		if handleErr != nil {
+			return handleErr
+		}
+		for _, developmentNode := range developmentNodes {
+			cache.addDevelopment(node, developmentNode)
 		}
 	}
 
+	return nil
+}
+
+func convertFromConfigNode(rootPath string, depsPath string, conf *Config, cache *Cache, mode Mode, useDevelopmentDependencies bool) (*DependencyNode, error) {
+	node, nodeErr := newDependencyNode(conf)
+	if nodeErr != nil {
+		return nil, nodeErr
+	}
+	cache.AddNode(conf.Name, node)
+
+	if err := resolveDependencies(rootPath, depsPath, node, conf, cache, mode, useDevelopmentDependencies); err != nil {
+		return nil, err
+	}
+
 	return node, nil
 }
 
-func CalculateTotalDependencies(rootPath string, depsPath string, conf *Config, mode Mode, useDevelopmentDependencies bool) (*Cache, *DependencyNode, error) {
-	cache := NewCache()
+func CalculateTotalDependencies(rootPath string, depsPath string, conf *Config, mode Mode, useDevelopmentDependencies bool, lockFile *LockFile, downloads *DownloadCache, jobCount int) (*Cache, *DependencyNode, error) {
+	cache := NewCache(lockFile, downloads, jobCount)
+	return calculateTotalDependenciesWithCache(rootPath, depsPath, conf, mode, useDevelopmentDependencies, cache)
+}
+
+func calculateTotalDependenciesWithCache(rootPath string, depsPath string, conf *Config, mode Mode, useDevelopmentDependencies bool, cache *Cache) (*Cache, *DependencyNode, error) {
+	cache.RootSource = conf.Source
 	rootNode, rootNodeErr := convertFromConfigNode(rootPath, depsPath, conf, cache, mode, useDevelopmentDependencies)
-	return cache, rootNode, rootNodeErr
+	if rootNodeErr != nil {
+		return nil, nil, rootNodeErr
+	}
+	if err := checkForCycles(cache, rootNode); err != nil {
+		return nil, nil, err
+	}
+	if err := cache.CheckRequirements(); err != nil {
+		return nil, nil, err
+	}
+	return cache, rootNode, nil
 }
 
 func isInList(dependencies []*DependencyNode, dependencyToCheck *DependencyNode) bool {
@@ -359,48 +778,233 @@ func whoDependsOnThisExcept(dependencies []*DependencyNode, dependencyToCheck *D
 	return foundDependencies
 }
 
-func SetupDependencies(filename string, mode Mode, forceClean bool, useDevelopmentDependencies bool, localPackageRoot string, depsTargetPathOverride string) (*DependencyInfo, error) {
+// transitiveDependencies returns every node that the node depends on, directly or indirectly.
+func transitiveDependencies(node *DependencyNode) []*DependencyNode {
+	visited := make(map[*DependencyNode]bool)
+	var result []*DependencyNode
+	var visit func(*DependencyNode)
+	visit = func(current *DependencyNode) {
+		for _, dependency := range current.dependencies {
+			if visited[dependency] {
+				continue
+			}
+			visited[dependency] = true
+			result = append(result, dependency)
+			visit(dependency)
+		}
+	}
+	visit(node)
+	return result
+}
+
+// developmentDependencies returns the development dependencies of the roots, and what they depend on,
+// except the nodes that are already in normal.
+func developmentDependencies(roots []*DependencyNode, normal []*DependencyNode) []*DependencyNode {
+	excluded := make(map[*DependencyNode]bool)
+	for _, node := range append(append([]*DependencyNode{}, roots...), normal...) {
+		excluded[node] = true
+	}
+
+	var result []*DependencyNode
+	for _, root := range roots {
+		for _, developmentNode := range root.development {
+			for _, node := range append([]*DependencyNode{developmentNode}, transitiveDependencies(developmentNode)...) {
+				if !excluded[node] {
+					excluded[node] = true
+					result = append(result, node)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func usesLockFile(mode Mode) bool {
+	return mode == Wget || mode == Clone
+}
+
+// SetupOptions controls how SetupDependencies resolves and fetches the dependencies.
+type SetupOptions struct {
+	Mode                       Mode
+	ForceClean                 bool
+	UseDevelopmentDependencies bool
+	LocalPackageRoot           string
+	TargetDepsPath             string
+	Update                     bool
+	Offline                    bool
+	JobCount                   int
+	Git                        GitOptions
+	Features                   []string
+	TargetOS                   string
+}
+
+func SetupDependencies(filename string, options SetupOptions) (*DependencyInfo, error) {
+	targetOS := options.TargetOS
+	if targetOS == "" {
+		targetOS = DefaultTargetOS()
+	}
+	if err := ValidateTargetOS(targetOS); err != nil {
+		return nil, err
+	}
+
 	conf, confErr := ReadConfigFromFilename(filename)
 	if confErr != nil {
 		return nil, confErr
 	}
 
+	workspaceFilename, workspaceConf, workspaceErr := findWorkspace(filename, conf)
+	if workspaceErr != nil {
+		return nil, workspaceErr
+	}
+	var members []*Config
+	configFilename := filename
+	if workspaceConf != nil {
+		log.Printf("using workspace '%v'\n", workspaceFilename)
+		configFilename = workspaceFilename
+		var membersErr error
+		members, membersErr = readWorkspaceMembers(workspaceFilename, workspaceConf)
+		if membersErr != nil {
+			return nil, membersErr
+		}
+		if err := enableWorkspaceFeatures(workspaceFilename, members, options.Features); err != nil {
+			return nil, err
+		}
+	} else {
+		var unknownFeatures []string
+		conf, unknownFeatures = conf.withFeatures(options.Features)
+		if len(unknownFeatures) > 0 {
+			return nil, unknownFeaturesError(filename, unknownFeatures)
+		}
+	}
+
+	lockFilename := LockFilenameFromConfigFilename(configFilename)
+	var lockFile *LockFile
+	if usesLockFile(options.Mode) && !options.Update {
+		var lockErr error
+		lockFile, lockErr = ReadLockFile(lockFilename)
+		if lockErr != nil {
+			return nil, lockErr
+		}
+	}
+
 	var packageRootPath string
 
 	var rootPath string
 
-	if localPackageRoot == "" {
+	if options.LocalPackageRoot == "" {
 		packageRootPath = path.Dir(filename)
 		rootPath = path.Dir(path.Dir(packageRootPath))
 	} else {
-		rootPath = localPackageRoot
+		rootPath = options.LocalPackageRoot
 	}
 
-	depsPath := filepath.Join(path.Dir(filename), "deps/")
-	if depsTargetPathOverride != "" {
-		depsPath = depsTargetPathOverride
+	depsPath := filepath.Join(path.Dir(configFilename), "deps/")
+	if options.TargetDepsPath != "" {
+		depsPath = options.TargetDepsPath
 	}
 
-	if mode != ReadLocal {
-		if mode != Clone || forceClean {
-			if err := BackupDeps(depsPath); err != nil {
-				return nil, err
+	var staged *StagedDeps
+	fetchPath := depsPath
+	if options.Mode != ReadLocal {
+		if options.Mode != Clone || options.ForceClean {
+			var stageErr error
+			staged, stageErr = StageDeps(depsPath)
+			if stageErr != nil {
+				return nil, stageErr
 			}
+			fetchPath = staged.StagingPath
+		} else {
+			os.Mkdir(depsPath, 0755)
+		}
+	}
+
+	downloads, downloadsErr := NewDownloadCache(options.Offline)
+	if downloadsErr != nil {
+		if staged != nil {
+			staged.Discard()
+		}
+		return nil, downloadsErr
+	}
+
+	userOverrideFilename, userOverrideErr := DefaultUserOverrideFilename()
+	if userOverrideErr != nil {
+		userOverrideFilename = ""
+	}
+	overrides, overridesErr := ReadOverrides(userOverrideFilename, configFilename)
+	if overridesErr != nil {
+		if staged != nil {
+			staged.Discard()
+		}
+		return nil, overridesErr
+	}
+
+	cache := NewCache(lockFile, downloads, options.JobCount)
+	cache.Git = options.Git
+	cache.Staged = staged
+	cache.Overrides = overrides
+	cache.TargetOS = targetOS
+	if options.Mode == Wget && staged != nil {
+		previousState, stateErr := ReadDepsState(depsPath)
+		if stateErr != nil {
+			log.Printf("ignoring previous state: %v", stateErr)
+			previousState = NewDepsState()
 		}
-		os.Mkdir(depsPath, 0755)
+		cache.PreviousState = previousState
+		cache.PreviousDepsPath = depsPath
 	}
 
-	cache, rootNode, rootNodeErr := CalculateTotalDependencies(rootPath, depsPath, conf, mode, useDevelopmentDependencies)
+	var rootNode *DependencyNode
+	var rootNodeErr error
+	if workspaceConf != nil {
+		rootNode, rootNodeErr = calculateWorkspaceDependencies(rootPath, fetchPath, workspaceConf, members, options.Mode, options.UseDevelopmentDependencies, cache)
+		if rootNodeErr == nil && options.Mode != ReadLocal {
+			rootNodeErr = linkWorkspaceMembers(fetchPath, members)
+		}
+	} else {
+		_, rootNode, rootNodeErr = calculateTotalDependenciesWithCache(rootPath, fetchPath, conf, options.Mode, options.UseDevelopmentDependencies, cache)
+	}
 	if rootNodeErr != nil {
+		if staged != nil {
+			if discardErr := staged.Discard(); discardErr != nil {
+				log.Printf("could not remove '%v': %v", staged.StagingPath, discardErr)
+			}
+		}
 		return nil, rootNodeErr
 	}
-	var rootNodes []*DependencyNode
-	for _, node := range cache.Nodes {
-		if node.name == rootNode.name {
-			continue
+
+	if options.Mode == Wget && staged != nil {
+		state := depsStateFromCache(cache)
+		for _, name := range state.RemovedPackages(cache.PreviousState) {
+			log.Printf("removing '%v', it is no longer a dependency\n", name)
+		}
+		if err := WriteDepsState(staged.StagingPath, state); err != nil {
+			staged.Discard()
+			return nil, err
+		}
+	}
+
+	if staged != nil {
+		if err := staged.Commit(); err != nil {
+			return nil, err
+		}
+	}
+
+	if usesLockFile(options.Mode) {
+		if err := WriteLockFile(lockFilename, lockFileFromCache(cache, rootNode)); err != nil {
+			return nil, err
+		}
+	}
+	developmentRoots := []*DependencyNode{rootNode}
+	if workspaceConf != nil {
+		if memberNode := cache.FindNode(conf.Name); memberNode != nil && workspaceConf != conf {
+			rootNode = memberNode
+			developmentRoots = []*DependencyNode{rootNode}
+		} else {
+			developmentRoots = append(developmentRoots, rootNode.dependencies...)
 		}
-		rootNodes = append(rootNodes, node)
 	}
+	rootNodes := transitiveDependencies(rootNode)
+	developmentNodes := developmentDependencies(developmentRoots, rootNodes)
 
 	for _, nodeToCheck := range cache.Nodes {
 		for _, localNode := range nodeToCheck.dependencies {
@@ -411,7 +1015,9 @@ func SetupDependencies(filename string, mode Mode, forceClean bool, useDevelopme
 		}
 	}
 
-	info := &DependencyInfo{RootPath: rootPath, PackageRootPath: packageRootPath, RootNode: rootNode, RootNodes: rootNodes}
+	info := &DependencyInfo{RootPath: rootPath, PackageRootPath: packageRootPath, DepsPath: depsPath,
+		Features: sortedFeatures(options.Features), TargetOS: targetOS, RootNode: rootNode, RootNodes: rootNodes,
+		DevelopmentNodes: developmentNodes}
 
 	return info, nil
 }
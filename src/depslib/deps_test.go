@@ -0,0 +1,370 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPackageContent(name string, dependencies ...string) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "depsversion = \"0.0.0\"\nname = \"%v\"\nversion = \"1.0.0\"\n", name)
+	for _, dependency := range dependencies {
+		fmt.Fprintf(&builder, "\n[[dependencies]]\nname = \"%v\"\nversion = \"*\"\n", dependency)
+	}
+	return builder.String()
+}
+
+func TestParallelDiamond(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/left", "piot/right", "piot/middle"))
+	writeTestPackage(t, rootPath, "piot/left", testPackageContent("piot/left", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/right", testPackageContent("piot/right", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/middle", testPackageContent("piot/middle", "piot/base", "piot/left"))
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base"))
+
+	conf, confErr := ReadConfigFromDirectory(filepath.Join(rootPath, "piot/app"))
+	if confErr != nil {
+		t.Fatal(confErr)
+	}
+
+	cache, rootNode, err := CalculateTotalDependencies(rootPath, "", conf, ReadLocal, false, nil, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cache.Nodes) != 5 {
+		t.Errorf("wrong node count %d", len(cache.Nodes))
+	}
+
+	dependencies := rootNode.Dependencies()
+	if len(dependencies) != 3 || dependencies[0].Name() != "piot/left" || dependencies[2].Name() != "piot/middle" {
+		t.Errorf("dependencies should keep the deps.toml order %v", dependencies)
+	}
+
+	base := cache.FindNode("piot/base")
+	if len(base.dependingOnThis) != 3 {
+		t.Errorf("base should have three dependents, has %v", base.dependingOnThis)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/a"))
+	writeTestPackage(t, rootPath, "piot/a", testPackageContent("piot/a", "piot/b"))
+	writeTestPackage(t, rootPath, "piot/b", testPackageContent("piot/b", "piot/c"))
+	writeTestPackage(t, rootPath, "piot/c", testPackageContent("piot/c", "piot/a"))
+
+	conf, confErr := ReadConfigFromDirectory(filepath.Join(rootPath, "piot/app"))
+	if confErr != nil {
+		t.Fatal(confErr)
+	}
+
+	_, _, err := CalculateTotalDependencies(rootPath, "", conf, ReadLocal, false, nil, nil, 0)
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	report := err.Error()
+	if !strings.Contains(report, "piot/a -> piot/b -> piot/c -> piot/a") {
+		t.Errorf("report should contain the cycle: %v", report)
+	}
+	if !strings.Contains(report, filepath.Join(rootPath, "piot/c/deps.toml")) {
+		t.Errorf("report should contain the file that closes the cycle: %v", report)
+	}
+}
+
+func TestCycleInWgetMode(t *testing.T) {
+	isolateTestEnvironment(t)
+	serverDirectory := t.TempDir()
+	writeTestZip(t, filepath.Join(serverDirectory, "a-latest.zip"), map[string]string{"a/deps.toml": testPackageContent("piot/a", "piot/b")})
+	writeTestZip(t, filepath.Join(serverDirectory, "b-latest.zip"), map[string]string{"b/deps.toml": testPackageContent("piot/b", "piot/a")})
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDirectory)))
+	defer server.Close()
+
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+server.URL+`/{repo}-{ref}.zip"
+
+[[dependencies]]
+name = "piot/a"
+version = "*"
+`)
+
+	_, err := SetupDependencies(filepath.Join(rootPath, "piot/app/deps.toml"), SetupOptions{})
+	var cycleErr *CycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("expected cycle error, got %v", err)
+	}
+
+	report := err.Error()
+	if !strings.Contains(report, filepath.Join(rootPath, "piot/app/deps/piot/b/deps.toml")) || strings.Contains(report, depsStagingSuffix) {
+		t.Errorf("report should refer to deps/ and not the removed staging directory: %v", report)
+	}
+}
+
+func TestGraphOutput(t *testing.T) {
+	isolateTestEnvironment(t)
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/left", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/left", testPackageContent("piot/left", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base"))
+
+	info, err := SetupDependencies(filepath.Join(rootPath, "piot/app/deps.toml"), SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	graph := NewGraph(info)
+	if len(graph.Nodes) != 3 || graph.Nodes[0].Name != "piot/app" || graph.Nodes[1].Name != "piot/base" {
+		t.Fatalf("wrong graph nodes %v", graph.Nodes)
+	}
+	base := graph.Nodes[1]
+	if strings.Join(base.DependingOnThis, ",") != "piot/app,piot/left" || base.ArtifactType != "lib" {
+		t.Errorf("wrong base node %v", base)
+	}
+
+	var jsonOutput bytes.Buffer
+	if err := graph.WriteJSON(&jsonOutput); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Graph
+	if err := json.Unmarshal(jsonOutput.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Root != "piot/app" || len(decoded.Nodes[0].Dependencies) != 2 {
+		t.Errorf("wrong decoded graph %v", decoded)
+	}
+
+	var dotOutput bytes.Buffer
+	if err := graph.WriteDot(&dotOutput); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dotOutput.String(), "\"piot/left\" -> \"piot/base\";") {
+		t.Errorf("missing edge in %v", dotOutput.String())
+	}
+}
+
+func TestWhy(t *testing.T) {
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/left", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/left", testPackageContent("piot/left", "piot/base"))
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base"))
+
+	conf, confErr := ReadConfigFromDirectory(filepath.Join(rootPath, "piot/app"))
+	if confErr != nil {
+		t.Fatal(confErr)
+	}
+	_, rootNode, err := CalculateTotalDependencies(rootPath, "", conf, ReadLocal, false, nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, whyErr := Why(rootNode, "piot/base")
+	if whyErr != nil {
+		t.Fatal(whyErr)
+	}
+	if len(paths) != 2 || paths[0].String() != "piot/app -> piot/left -> piot/base" || paths[1].String() != "piot/app -> piot/base" {
+		t.Fatalf("wrong paths %v", paths)
+	}
+
+	if len(paths[0].RedundantEdges()) != 0 {
+		t.Errorf("first path should not be redundant")
+	}
+	redundant := paths[1].RedundantEdges()
+	if len(redundant) != 1 || redundant[0][0].Name() != "piot/left" {
+		t.Errorf("direct dependency on base should be redundant because of left %v", redundant)
+	}
+
+	if _, err := Why(rootNode, "piot/unknown"); err == nil {
+		t.Errorf("expected error for unknown package")
+	}
+}
+
+func TestWhyDevelopmentDependency(t *testing.T) {
+	isolateTestEnvironment(t)
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/base")+`
+[[development]]
+name = "piot/testing"
+version = "*"
+`)
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base"))
+	writeTestPackage(t, rootPath, "piot/testing", testPackageContent("piot/testing", "piot/base", "piot/mock"))
+	writeTestPackage(t, rootPath, "piot/mock", testPackageContent("piot/mock"))
+
+	info, err := SetupDependencies(filepath.Join(rootPath, "piot/app/deps.toml"),
+		SetupOptions{Mode: ReadLocal, UseDevelopmentDependencies: true, LocalPackageRoot: rootPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, whyErr := Why(info.RootNode, "piot/mock")
+	if whyErr != nil {
+		t.Fatal(whyErr)
+	}
+	if len(paths) != 1 || paths[0].String() != "piot/app -> piot/testing (dev) -> piot/mock" {
+		t.Errorf("wrong paths %v", paths)
+	}
+
+	paths, whyErr = Why(info.RootNode, "piot/base")
+	if whyErr != nil {
+		t.Fatal(whyErr)
+	}
+	if len(paths) != 2 || paths[0].String() != "piot/app -> piot/base" || paths[1].String() != "piot/app -> piot/testing (dev) -> piot/base" {
+		t.Errorf("wrong paths %v", paths)
+	}
+	if len(paths[1].RedundantEdges()) != 0 {
+		t.Errorf("development path should not be redundant %v", paths[1].RedundantEdges())
+	}
+}
+
+func TestPathDependency(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, "app/deps.toml"), `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+env.source()+`"
+
+[[dependencies]]
+name = "piot/lightning"
+version = "*"
+path = "../lightning"
+`)
+	writeTestFile(t, filepath.Join(directory, "lightning/deps.toml"), `depsversion = "0.0.0"
+name = "piot/lightning"
+version = "0.5.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	writeTestFile(t, filepath.Join(directory, "lightning/src/lib/lightning.c"), "")
+
+	info, err := SetupDependencies(filepath.Join(directory, "app/deps.toml"), SetupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lightning := info.RootNode.Dependencies()[0]
+	if lightning.Path() != filepath.Join(directory, "lightning") || lightning.Version().String() != "0.5.0" {
+		t.Errorf("wrong path dependency %v '%v'", lightning, lightning.Path())
+	}
+	if thunder := lightning.Dependencies()[0]; thunder.Name() != "piot/thunder" || thunder.checksum == "" {
+		t.Errorf("dependencies of the path dependency should be fetched %v", thunder)
+	}
+	if !fileExists(filepath.Join(directory, "app/deps/piot/lightning/src/lib/lightning.c")) {
+		t.Errorf("path dependency should be linked into deps/")
+	}
+
+	lockFile, _ := ReadLockFile(filepath.Join(directory, "app/deps.lock"))
+	if lockFile == nil || len(lockFile.Packages) != 1 || lockFile.Packages[0].Name != "piot/thunder" {
+		t.Errorf("path dependencies should not be locked %v", lockFile)
+	}
+
+	graph := NewGraph(info)
+	if graph.Nodes[1].Source != "path:"+filepath.Join(directory, "lightning") {
+		t.Errorf("graph should show the path %v", graph.Nodes[1])
+	}
+}
+
+func TestIllegalRequirementFetchesNothing(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+env.source()+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+
+[[dependencies]]
+name = "piot/lightning"
+version = "not a version"
+`)
+
+	_, err := SetupDependencies(filepath.Join(rootPath, "piot/app/deps.toml"), SetupOptions{})
+	if err == nil || !strings.Contains(err.Error(), "piot/lightning") {
+		t.Fatalf("expected error for illegal version constraint, got %v", err)
+	}
+	if env.requestCount.Load() != 0 {
+		t.Errorf("nothing should be fetched when a requirement is illegal, %d requests", env.requestCount.Load())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(rootPath, "piot/app/deps.[bcs]*")); len(leftovers) != 0 {
+		t.Errorf("staging directory should be removed %v", leftovers)
+	}
+}
+
+func TestDevelopmentDependencies(t *testing.T) {
+	isolateTestEnvironment(t)
+	rootPath := t.TempDir()
+	developmentSection := func(name string) string {
+		return fmt.Sprintf("\n[[development]]\nname = \"%v\"\nversion = \"*\"\n", name)
+	}
+	writeTestPackage(t, rootPath, "piot/app", testPackageContent("piot/app", "piot/base")+developmentSection("piot/testing"))
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base")+developmentSection("piot/not-fetched"))
+	writeTestPackage(t, rootPath, "piot/testing", testPackageContent("piot/testing", "piot/base", "piot/mock"))
+	writeTestPackage(t, rootPath, "piot/mock", testPackageContent("piot/mock"))
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+
+	info, err := SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.RootNodes) != 1 || len(info.DevelopmentNodes) != 0 {
+		t.Errorf("development dependencies should only be used with --dev %v %v", info.RootNodes, info.DevelopmentNodes)
+	}
+
+	info, err = SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, UseDevelopmentDependencies: true, LocalPackageRoot: rootPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := sortedNodeNames(info.RootNodes); len(names) != 1 || names[0] != "piot/base" {
+		t.Errorf("development dependencies should not be in RootNodes %v", names)
+	}
+	if names := sortedNodeNames(info.DevelopmentNodes); len(names) != 2 || names[0] != "piot/mock" || names[1] != "piot/testing" {
+		t.Errorf("wrong development nodes %v", names)
+	}
+	if development := info.RootNode.Development(); len(development) != 1 || development[0].Name() != "piot/testing" {
+		t.Errorf("root should have a development edge to piot/testing %v", development)
+	}
+
+	graph := NewGraph(info)
+	if len(graph.Nodes) != 4 || strings.Join(graph.Nodes[0].DevDependencies, ",") != "piot/testing" {
+		t.Fatalf("graph should include development dependencies %v", graph.Nodes)
+	}
+	for _, node := range graph.Nodes {
+		if node.DevOnly != (node.Name == "piot/testing" || node.Name == "piot/mock") {
+			t.Errorf("wrong devOnly for %v", node.Name)
+		}
+	}
+
+	var dotOutput bytes.Buffer
+	if err := graph.WriteDot(&dotOutput); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dotOutput.String(), "\"piot/app\" -> \"piot/testing\" [style=dashed, label=\"dev\"];") {
+		t.Errorf("missing development edge in %v", dotOutput.String())
+	}
+}
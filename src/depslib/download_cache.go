@@ -0,0 +1,323 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// DownloadCache is a user level cache of downloaded archives. The archives are stored by their sha256 checksum
+// in archives/ and refs/<owner>/<repo>/ remembers which checksum a ref resolved to.
+type DownloadCache struct {
+	Directory string
+	Offline   bool
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+type DownloadCacheEntry struct {
+	Name     string    `toml:"name"`
+	Ref      string    `toml:"ref"`
+	Commit   string    `toml:"commit"`
+	Checksum string    `toml:"checksum"`
+	LastUsed time.Time `toml:"lastused"`
+}
+
+func (e DownloadCacheEntry) String() string {
+	return fmt.Sprintf("%v %v %v %v", e.Name, e.Ref, e.Commit, e.Checksum)
+}
+
+// DefaultDownloadCacheDirectory is $DEPS_CACHE_DIR, or deps/ in the user cache directory ($XDG_CACHE_HOME on Linux).
+func DefaultDownloadCacheDirectory() (string, error) {
+	if directory := os.Getenv("DEPS_CACHE_DIR"); directory != "" {
+		return directory, nil
+	}
+	userCacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDirectory, "deps"), nil
+}
+
+func NewDownloadCache(offline bool) (*DownloadCache, error) {
+	directory, directoryErr := DefaultDownloadCacheDirectory()
+	if directoryErr != nil {
+		return nil, directoryErr
+	}
+	return &DownloadCache{Directory: directory, Offline: offline}, nil
+}
+
+func (c *DownloadCache) archiveFilename(checksum string) string {
+	return filepath.Join(c.Directory, "archives", checksum)
+}
+
+func (c *DownloadCache) refsDirectory() string {
+	return filepath.Join(c.Directory, "refs")
+}
+
+func refKey(ref Ref) string {
+	if ref.IsDefault() {
+		return "HEAD"
+	}
+	return ref.String()
+}
+
+func (c *DownloadCache) entryFilename(repoName string, ref Ref) string {
+	return filepath.Join(c.refsDirectory(), RepoNameToShortName(repoName), url.PathEscape(refKey(ref))+".toml")
+}
+
+func readDownloadCacheEntry(filename string) (*DownloadCacheEntry, error) {
+	content, readErr := ioutil.ReadFile(filename)
+	if readErr != nil {
+		return nil, readErr
+	}
+	entry := &DownloadCacheEntry{}
+	if err := toml.Unmarshal(content, entry); err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+	return entry, nil
+}
+
+func (c *DownloadCache) writeEntry(entry DownloadCacheEntry, ref Ref) error {
+	filename := c.entryFilename(entry.Name, ref)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if err := toml.NewEncoder(&buffer).Order(toml.OrderPreserve).Encode(entry); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, buffer.Bytes(), 0644)
+}
+
+func (c *DownloadCache) findEntry(repoName string, ref Ref) *DownloadCacheEntry {
+	entry, readErr := readDownloadCacheEntry(c.entryFilename(repoName, ref))
+	if readErr != nil {
+		return nil
+	}
+	if !fileExists(c.archiveFilename(entry.Checksum)) {
+		return nil
+	}
+	return entry
+}
+
+func fileExists(filename string) bool {
+	stat, statErr := os.Stat(filename)
+	return statErr == nil && !stat.IsDir()
+}
+
+// isImmutableRef returns true for refs that should always give the same archive, so the cache can be used
+// without asking the server.
+func isImmutableRef(ref Ref) bool {
+	return ref.Kind == Tag || ref.Kind == Revision
+}
+
+// Fetch returns the filename of the archive for the ref. Archives with a known checksum, and archives for tags and
+// revisions, are only downloaded if they are not cached. Default branches and branches are always downloaded again,
+// unless the cache is offline.
+func (c *DownloadCache) Fetch(repoName string, source Source, ref Ref, checksum string) (string, DownloadCacheEntry, error) {
+	if checksum != "" && fileExists(c.archiveFilename(checksum)) {
+		entry := c.findEntry(repoName, ref)
+		if entry == nil || entry.Checksum != checksum {
+			archiveFilename := c.archiveFilename(checksum)
+			entry = &DownloadCacheEntry{Name: repoName, Ref: refKey(ref), Commit: archiveCommit(archiveFilename), Checksum: checksum}
+		}
+		return c.use(*entry, ref)
+	}
+
+	if checksum == "" && (isImmutableRef(ref) || c.Offline) {
+		if entry := c.findEntry(repoName, ref); entry != nil {
+			return c.use(*entry, ref)
+		}
+	}
+
+	if c.Offline {
+		return "", DownloadCacheEntry{}, fmt.Errorf("'%v' at '%v' is not in the download cache '%v' and deps is offline", repoName, refKey(ref), c.Directory)
+	}
+
+	downloadURL, urlErr := source.ArchiveURL(repoName, ref)
+	if urlErr != nil {
+		return "", DownloadCacheEntry{}, urlErr
+	}
+
+	return c.download(repoName, ref, downloadURL, checksum)
+}
+
+func (c *DownloadCache) use(entry DownloadCacheEntry, ref Ref) (string, DownloadCacheEntry, error) {
+	log.Printf("using cached '%v' at '%v'\n", entry.Name, entry.Ref)
+	entry.LastUsed = time.Now()
+	if err := c.writeEntry(entry, ref); err != nil {
+		return "", DownloadCacheEntry{}, err
+	}
+	return c.archiveFilename(entry.Checksum), entry, nil
+}
+
+// download verifies the archive against the expected checksum, if there is one, before it is stored in the cache.
+func (c *DownloadCache) download(repoName string, ref Ref, downloadURL *url.URL, expectedChecksum string) (string, DownloadCacheEntry, error) {
+	tempDirectory := filepath.Join(c.Directory, "tmp")
+	if err := os.MkdirAll(tempDirectory, 0755); err != nil {
+		return "", DownloadCacheEntry{}, err
+	}
+	tempFile, tempErr := ioutil.TempFile(tempDirectory, "download")
+	if tempErr != nil {
+		return "", DownloadCacheEntry{}, tempErr
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	log.Printf("downloading from '%v'\n", downloadURL.Redacted())
+	if err := HTTPGet(downloadURL, tempFile.Name()); err != nil {
+		return "", DownloadCacheEntry{}, err
+	}
+
+	checksum, checksumErr := fileChecksum(tempFile.Name())
+	if checksumErr != nil {
+		return "", DownloadCacheEntry{}, checksumErr
+	}
+	if expectedChecksum != "" && checksum != expectedChecksum {
+		return "", DownloadCacheEntry{}, fmt.Errorf("%w for '%v' from '%v': expected %v, got %v", errChecksumMismatch, repoName, downloadURL.Redacted(), expectedChecksum, checksum)
+	}
+
+	archiveFilename := c.archiveFilename(checksum)
+	if err := os.MkdirAll(filepath.Dir(archiveFilename), 0755); err != nil {
+		return "", DownloadCacheEntry{}, err
+	}
+	if err := os.Rename(tempFile.Name(), archiveFilename); err != nil {
+		return "", DownloadCacheEntry{}, err
+	}
+
+	entry := DownloadCacheEntry{Name: repoName, Ref: refKey(ref), Commit: archiveCommit(archiveFilename), Checksum: checksum}
+	return c.use(entry, ref)
+}
+
+func (c *DownloadCache) entryFilenames() ([]string, error) {
+	var filenames []string
+	walkErr := filepath.Walk(c.refsDirectory(), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".toml") {
+			filenames = append(filenames, path)
+		}
+		return nil
+	})
+	return filenames, walkErr
+}
+
+// List returns all cached refs, sorted by package name and ref.
+func (c *DownloadCache) List() ([]DownloadCacheEntry, error) {
+	filenames, filenamesErr := c.entryFilenames()
+	if filenamesErr != nil {
+		return nil, filenamesErr
+	}
+
+	var entries []DownloadCacheEntry
+	for _, filename := range filenames {
+		entry, readErr := readDownloadCacheEntry(filename)
+		if readErr != nil {
+			return nil, readErr
+		}
+		entries = append(entries, *entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Ref < entries[j].Ref
+	})
+
+	return entries, nil
+}
+
+// Prune removes refs that have not been used for maxAge, and the archives and unfinished downloads older than maxAge
+// that no ref is using. It returns the number of removed archives.
+func (c *DownloadCache) Prune(maxAge time.Duration) (int, error) {
+	filenames, filenamesErr := c.entryFilenames()
+	if filenamesErr != nil {
+		return 0, filenamesErr
+	}
+
+	usedChecksums := make(map[string]bool)
+	oldest := time.Now().Add(-maxAge)
+	for _, filename := range filenames {
+		entry, readErr := readDownloadCacheEntry(filename)
+		if readErr != nil || entry.LastUsed.Before(oldest) {
+			if err := os.Remove(filename); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		usedChecksums[entry.Checksum] = true
+	}
+
+	archives, archivesErr := ioutil.ReadDir(filepath.Join(c.Directory, "archives"))
+	if os.IsNotExist(archivesErr) {
+		return 0, nil
+	}
+	if archivesErr != nil {
+		return 0, archivesErr
+	}
+
+	removedCount := 0
+	for _, archive := range archives {
+		// a fetch that is running right now moves the archive into place before it writes its ref
+		if usedChecksums[archive.Name()] || !archive.ModTime().Before(oldest) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Directory, "archives", archive.Name())); err != nil {
+			return removedCount, err
+		}
+		removedCount++
+	}
+
+	return removedCount, c.pruneTempFiles(oldest)
+}
+
+// pruneTempFiles only removes old downloads, since running fetches download into the same directory.
+func (c *DownloadCache) pruneTempFiles(oldest time.Time) error {
+	tempDirectory := filepath.Join(c.Directory, "tmp")
+	tempFiles, readErr := ioutil.ReadDir(tempDirectory)
+	if os.IsNotExist(readErr) {
+		return nil
+	}
+	if readErr != nil {
+		return readErr
+	}
+
+	for _, tempFile := range tempFiles {
+		if !tempFile.ModTime().Before(oldest) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(tempDirectory, tempFile.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *DownloadCache) Clear() error {
+	log.Printf("removing download cache '%v'\n", c.Directory)
+	return os.RemoveAll(c.Directory)
+}
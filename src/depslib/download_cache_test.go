@@ -0,0 +1,200 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestArchiveServer(t *testing.T, requestCount *atomic.Int32) *httptest.Server {
+	serverDirectory := t.TempDir()
+	writeTestCommitZip(t, filepath.Join(serverDirectory, "thunder-v1.zip"), testCommit, map[string]string{
+		"thunder/deps.toml": "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.0.0\"\n",
+	})
+	fileServer := http.FileServer(http.Dir(serverDirectory))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		fileServer.ServeHTTP(w, r)
+	}))
+}
+
+func TestDownloadCacheTag(t *testing.T) {
+	var requestCount atomic.Int32
+	server := newTestArchiveServer(t, &requestCount)
+	defer server.Close()
+
+	source, _ := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	downloads := &DownloadCache{Directory: t.TempDir()}
+	ref := Ref{Kind: Tag, Name: "v1"}
+
+	firstFilename, firstEntry, firstErr := downloads.Fetch("piot/thunder", source, ref, "")
+	if firstErr != nil {
+		t.Fatal(firstErr)
+	}
+
+	secondFilename, _, secondErr := downloads.Fetch("piot/thunder", source, ref, "")
+	if secondErr != nil {
+		t.Fatal(secondErr)
+	}
+
+	if requestCount.Load() != 1 {
+		t.Errorf("tag should only be downloaded once, was downloaded %d times", requestCount.Load())
+	}
+	if firstFilename != secondFilename || filepath.Base(firstFilename) != firstEntry.Checksum {
+		t.Errorf("archive should be stored by checksum %v: %v %v", firstEntry.Checksum, firstFilename, secondFilename)
+	}
+
+	entries, listErr := downloads.List()
+	if listErr != nil {
+		t.Fatal(listErr)
+	}
+	if len(entries) != 1 || entries[0].Name != "piot/thunder" || entries[0].Ref != "tag:v1" {
+		t.Errorf("wrong entries %v", entries)
+	}
+}
+
+func TestDownloadCacheOffline(t *testing.T) {
+	var requestCount atomic.Int32
+	server := newTestArchiveServer(t, &requestCount)
+	defer server.Close()
+
+	source, _ := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	downloads := &DownloadCache{Directory: t.TempDir(), Offline: true}
+
+	_, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v1"}, "")
+	if err == nil {
+		t.Fatalf("expected offline error")
+	}
+	if requestCount.Load() != 0 {
+		t.Errorf("offline cache should not download")
+	}
+
+	downloads.Offline = false
+	if _, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	downloads.Offline = true
+	if _, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v1"}, ""); err != nil {
+		t.Errorf("cached archive should be available offline: %v", err)
+	}
+}
+
+func TestDownloadCachePrune(t *testing.T) {
+	var requestCount atomic.Int32
+	server := newTestArchiveServer(t, &requestCount)
+	defer server.Close()
+
+	source, _ := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	downloads := &DownloadCache{Directory: t.TempDir()}
+	if _, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v1"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	downloadingFilename := filepath.Join(downloads.Directory, "tmp", "download-running")
+	writeTestFile(t, downloadingFilename, "partial")
+
+	removedCount, pruneErr := downloads.Prune(time.Hour)
+	if pruneErr != nil || removedCount != 0 {
+		t.Fatalf("recently used archive should be kept %d %v", removedCount, pruneErr)
+	}
+	if !fileExists(downloadingFilename) {
+		t.Errorf("a download that is running should not be removed")
+	}
+
+	removedCount, pruneErr = downloads.Prune(-time.Hour)
+	if pruneErr != nil || removedCount != 1 {
+		t.Fatalf("old archive should be removed %d %v", removedCount, pruneErr)
+	}
+	if fileExists(downloadingFilename) {
+		t.Errorf("old unfinished download should be removed")
+	}
+
+	entries, _ := downloads.List()
+	if len(entries) != 0 {
+		t.Errorf("expected empty cache, got %v", entries)
+	}
+}
+
+func TestDownloadCacheChecksumMismatch(t *testing.T) {
+	var requestCount atomic.Int32
+	server := newTestArchiveServer(t, &requestCount)
+	defer server.Close()
+
+	source, _ := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	downloads := &DownloadCache{Directory: t.TempDir()}
+
+	wrongChecksum := strings.Repeat("0", 64)
+	if _, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v1"}, wrongChecksum); err == nil {
+		t.Fatalf("expected checksum mismatch")
+	}
+
+	entries, _ := downloads.List()
+	if len(entries) != 0 {
+		t.Errorf("archive with wrong checksum should not be cached %v", entries)
+	}
+}
+
+func TestDownloadNotFound(t *testing.T) {
+	var requestCount atomic.Int32
+	server := newTestArchiveServer(t, &requestCount)
+	defer server.Close()
+
+	source, _ := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	downloads := &DownloadCache{Directory: t.TempDir()}
+
+	_, _, err := downloads.Fetch("piot/thunder", source, Ref{Kind: Tag, Name: "v2"}, "")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestVerificationFailureKeepsDeps(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	packageDirectory := filepath.Join(t.TempDir(), "piot/app")
+	writeTestPackage(t, filepath.Dir(filepath.Dir(packageDirectory)), "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+env.source()+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+sha256 = "`+strings.Repeat("0", 64)+`"
+`)
+	existingFile := filepath.Join(packageDirectory, "deps/piot/thunder/deps.toml")
+	if err := os.MkdirAll(filepath.Dir(existingFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existingFile, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := SetupDependencies(filepath.Join(packageDirectory, "deps.toml"), SetupOptions{})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	content, readErr := ioutil.ReadFile(existingFile)
+	if readErr != nil || string(content) != "existing" {
+		t.Errorf("deps/ should be untouched %v '%s'", readErr, content)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(packageDirectory, "deps.[bcs]*"))
+	if len(leftovers) != 0 {
+		t.Errorf("staging and backup directories should be removed %v", leftovers)
+	}
+}
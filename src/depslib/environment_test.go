@@ -0,0 +1,40 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// isolateTestEnvironment points DEPS_CACHE_DIR and DEPS_CONFIG_DIR at empty directories, so tests never share
+// downloads or read the override.toml of the user running them. It returns the config directory.
+func isolateTestEnvironment(t *testing.T) string {
+	configDirectory := t.TempDir()
+	t.Setenv("DEPS_CACHE_DIR", t.TempDir())
+	t.Setenv("DEPS_CONFIG_DIR", configDirectory)
+	return configDirectory
+}
+
+// testEnvironment is an isolated environment with an archive server that serves piot/thunder at v1.
+type testEnvironment struct {
+	server          *httptest.Server
+	requestCount    atomic.Int32
+	configDirectory string
+}
+
+func newTestEnvironment(t *testing.T) *testEnvironment {
+	env := &testEnvironment{configDirectory: isolateTestEnvironment(t)}
+	env.server = newTestArchiveServer(t, &env.requestCount)
+	t.Cleanup(env.server.Close)
+	return env
+}
+
+// source is the source template to use in deps.toml for the archive server.
+func (e *testEnvironment) source() string {
+	return e.server.URL + "/{repo}-{ref}.zip"
+}
@@ -0,0 +1,312 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxUncompressedArchiveSize is the most that is written when extracting a single archive.
+const maxUncompressedArchiveSize = 1 << 30
+
+// maxFollowedSymlinks is the most symlinks that are followed when checking where a symlink points to.
+const maxFollowedSymlinks = 255
+
+func isInsideDirectory(directory string, targetPath string) bool {
+	relativePath, relErr := filepath.Rel(directory, targetPath)
+	if relErr != nil {
+		return false
+	}
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+// safeTargetPath returns where an archive entry should be written, and fails if the name is absolute or escapes the
+// destination directory.
+func safeTargetPath(destinationDirectory string, name string) (string, error) {
+	if name == "" {
+		return filepath.Clean(destinationDirectory), nil
+	}
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry '%v' has an absolute path", name)
+	}
+
+	targetPath := filepath.Join(destinationDirectory, name)
+	if !isInsideDirectory(destinationDirectory, targetPath) {
+		return "", fmt.Errorf("archive entry '%v' is outside of the target directory", name)
+	}
+
+	return targetPath, nil
+}
+
+// checkNoSymlinkEscape fails if a symlink, written by an earlier entry, makes the directory resolve to somewhere
+// outside of the destination directory.
+func checkNoSymlinkEscape(destinationDirectory string, directory string) error {
+	resolvedDestination, destinationErr := filepath.EvalSymlinks(destinationDirectory)
+	if destinationErr != nil {
+		return destinationErr
+	}
+
+	existingDirectory := directory
+	for {
+		if _, statErr := os.Lstat(existingDirectory); statErr == nil {
+			break
+		}
+		existingDirectory = filepath.Dir(existingDirectory)
+	}
+
+	resolvedDirectory, resolveErr := filepath.EvalSymlinks(existingDirectory)
+	if resolveErr != nil {
+		return resolveErr
+	}
+	if !isInsideDirectory(resolvedDestination, resolvedDirectory) {
+		return fmt.Errorf("'%v' resolves to '%v', outside of the target directory", directory, resolvedDirectory)
+	}
+
+	return nil
+}
+
+type extractor struct {
+	destinationDirectory string
+	maxSize              int64
+	remainingSize        int64
+}
+
+func splitLinkPath(linkPath string) []string {
+	return strings.Split(filepath.ToSlash(linkPath), "/")
+}
+
+// resolveInside follows the path components from directory, and the symlinks that are already written on the way,
+// and fails if that ever leaves the destination directory. Components that do not exist yet are taken as they are.
+func (e *extractor) resolveInside(directory string, components []string, followedCount *int) (string, error) {
+	current := directory
+	for _, component := range components {
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if current == e.destinationDirectory {
+				return "", fmt.Errorf("'%v' is outside of the target directory", filepath.Join(components...))
+			}
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, component)
+		stat, statErr := os.Lstat(next)
+		if statErr != nil || stat.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		*followedCount++
+		if *followedCount > maxFollowedSymlinks {
+			return "", fmt.Errorf("too many symlinks in '%v'", next)
+		}
+		linkTarget, readErr := os.Readlink(next)
+		if readErr != nil {
+			return "", readErr
+		}
+		if filepath.IsAbs(linkTarget) || strings.HasPrefix(linkTarget, "/") {
+			return "", fmt.Errorf("'%v' points to absolute path '%v'", next, linkTarget)
+		}
+		resolved, resolveErr := e.resolveInside(current, splitLinkPath(linkTarget), followedCount)
+		if resolveErr != nil {
+			return "", resolveErr
+		}
+		current = resolved
+	}
+
+	return current, nil
+}
+
+// checkSymlink fails if the symlink at targetPath would point outside of the destination directory, also when it
+// goes through other symlinks.
+func (e *extractor) checkSymlink(targetPath string, linkTarget string) error {
+	if filepath.IsAbs(linkTarget) || strings.HasPrefix(linkTarget, "/") {
+		return fmt.Errorf("points to absolute path '%v'", linkTarget)
+	}
+	linkDirectory, relErr := filepath.Rel(e.destinationDirectory, filepath.Dir(targetPath))
+	if relErr != nil {
+		return relErr
+	}
+
+	followedCount := 0
+	components := append(splitLinkPath(linkDirectory), splitLinkPath(linkTarget)...)
+	if _, err := e.resolveInside(e.destinationDirectory, components, &followedCount); err != nil {
+		return fmt.Errorf("points to '%v', outside of the target directory: %w", linkTarget, err)
+	}
+
+	return nil
+}
+
+// checkAllSymlinks checks the symlinks again when everything is extracted, since later entries can replace the
+// directories and symlinks that an earlier symlink goes through.
+func (e *extractor) checkAllSymlinks() error {
+	return filepath.Walk(e.destinationDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return err
+		}
+		linkTarget, readErr := os.Readlink(path)
+		if readErr != nil {
+			return readErr
+		}
+		if checkErr := e.checkSymlink(path, linkTarget); checkErr != nil {
+			return fmt.Errorf("extracted symlink '%v' %w", path, checkErr)
+		}
+		return nil
+	})
+}
+
+func newExtractor(destinationDirectory string, maxSize int64) (*extractor, error) {
+	if err := os.MkdirAll(destinationDirectory, 0755); err != nil {
+		return nil, err
+	}
+	return &extractor{destinationDirectory: filepath.Clean(destinationDirectory), maxSize: maxSize, remainingSize: maxSize}, nil
+}
+
+func (e *extractor) mkdirAll(directory string) error {
+	if err := checkNoSymlinkEscape(e.destinationDirectory, directory); err != nil {
+		return err
+	}
+	return os.MkdirAll(directory, 0755)
+}
+
+func (e *extractor) writeFile(targetPath string, mode os.FileMode, reader io.Reader) (err error) {
+	if mkdirErr := e.mkdirAll(filepath.Dir(targetPath)); mkdirErr != nil {
+		return mkdirErr
+	}
+	if removeErr := removeSymlinkIfExists(targetPath); removeErr != nil {
+		return removeErr
+	}
+
+	permissions := mode.Perm()
+	if permissions == 0 {
+		permissions = 0644
+	}
+
+	targetFileWriter, createFileErr := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, permissions)
+	if createFileErr != nil {
+		return createFileErr
+	}
+	defer func() {
+		if closeErr := targetFileWriter.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	writtenSize, copyErr := io.Copy(targetFileWriter, io.LimitReader(reader, e.remainingSize+1))
+	if copyErr != nil {
+		return copyErr
+	}
+	e.remainingSize -= writtenSize
+	if e.remainingSize < 0 {
+		return fmt.Errorf("archive is larger than the allowed %d bytes when extracted", e.maxSize)
+	}
+
+	return nil
+}
+
+func (e *extractor) writeSymlink(targetPath string, name string, linkTarget string) error {
+	if err := e.checkSymlink(targetPath, linkTarget); err != nil {
+		return fmt.Errorf("archive symlink '%v' %w", name, err)
+	}
+
+	if err := e.mkdirAll(filepath.Dir(targetPath)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(targetPath); err != nil {
+		return err
+	}
+
+	return os.Symlink(linkTarget, targetPath)
+}
+
+func (e *extractor) extract(name string, mode os.FileMode, open func() (io.ReadCloser, error)) (err error) {
+	targetPath, targetErr := safeTargetPath(e.destinationDirectory, name)
+	if targetErr != nil {
+		return targetErr
+	}
+
+	if mode.IsDir() {
+		return e.mkdirAll(targetPath)
+	}
+
+	reader, openErr := open()
+	if openErr != nil {
+		return openErr
+	}
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	if mode&os.ModeSymlink != 0 {
+		linkTarget, readErr := ioutil.ReadAll(io.LimitReader(reader, 4096))
+		if readErr != nil {
+			return readErr
+		}
+		return e.writeSymlink(targetPath, name, string(linkTarget))
+	}
+
+	if !mode.IsRegular() {
+		return fmt.Errorf("archive entry '%v' has unsupported type %v", name, mode.Type())
+	}
+
+	return e.writeFile(targetPath, mode, reader)
+}
+
+func walkZip(zipFile string, entryFunc archiveEntryFunc) (err error) {
+	zipReader, openErr := zip.OpenReader(zipFile)
+	if openErr != nil {
+		return openErr
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, zipEntry := range zipReader.File {
+		if err := entryFunc(zipEntry.Name, zipEntry.Mode(), zipEntry.Open); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// zipCommit returns the commit that git archive stores as the comment of a zip archive, or an empty string if
+// the archive has no commit.
+func zipCommit(zipFile string) string {
+	zipReader, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return ""
+	}
+	defer zipReader.Close()
+
+	commit := strings.TrimSpace(zipReader.Comment)
+	if !isCommitHash(commit) {
+		return ""
+	}
+
+	return commit
+}
+
+func isCommitHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
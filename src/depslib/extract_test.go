@@ -0,0 +1,189 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestZip(t *testing.T, filename string, entries map[string]string) {
+	writeTestCommitZip(t, filename, "", entries)
+}
+
+// writeTestCommitZip writes the commit as the zip comment, like GitHub does.
+func writeTestCommitZip(t *testing.T, filename string, commit string, entries map[string]string) {
+	file, createErr := os.Create(filename)
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for name, content := range entries {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.SetComment(commit); err != nil {
+		t.Fatal(err)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnarchiveTopDirectory(t *testing.T) {
+	directory := t.TempDir()
+	zipFilename := filepath.Join(directory, "temp.zip")
+	writeTestZip(t, zipFilename, map[string]string{
+		"tiny-clib-1.0.2/deps.toml":          "name",
+		"tiny-clib-1.0.2/src/lib/tiny.c":     "int x;",
+		"tiny-clib-1.0.2/src/include/tiny.h": "",
+	})
+
+	prefix, prefixErr := archiveTopDirectory(zipFilename)
+	if prefixErr != nil {
+		t.Fatal(prefixErr)
+	}
+	if prefix != "tiny-clib-1.0.2/" {
+		t.Fatalf("wrong prefix '%v'", prefix)
+	}
+
+	targetDirectory := filepath.Join(directory, "piot/tiny-clib")
+	if err := extractArchive(zipFilename, targetDirectory, prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	content, readErr := ioutil.ReadFile(filepath.Join(targetDirectory, "src/lib/tiny.c"))
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(content) != "int x;" {
+		t.Errorf("wrong content '%s'", content)
+	}
+}
+
+type testZipEntry struct {
+	name    string
+	content string
+	mode    os.FileMode
+}
+
+func writeCraftedZip(t *testing.T, filename string, entries []testZipEntry) {
+	file, createErr := os.Create(filename)
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		mode := entry.mode
+		if mode == 0 {
+			mode = 0644
+		}
+		header.SetMode(mode)
+		writer, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		description string
+		entries     []testZipEntry
+	}{
+		{"path traversal", []testZipEntry{{name: "repo/../../escaped.txt", content: "x"}}},
+		{"absolute path", []testZipEntry{{name: "/tmp/escaped.txt", content: "x"}}},
+		{"symlink outside", []testZipEntry{{name: "repo/link", content: "../../outside", mode: os.ModeSymlink | 0777}}},
+		{"absolute symlink", []testZipEntry{{name: "repo/link", content: "/etc", mode: os.ModeSymlink | 0777}}},
+		{"symlink chain", []testZipEntry{
+			{name: "repo/a", content: "b/..", mode: os.ModeSymlink | 0777},
+			{name: "repo/b", content: "..", mode: os.ModeSymlink | 0777},
+		}},
+		{"symlink through symlink", []testZipEntry{
+			{name: "repo/x", content: ".", mode: os.ModeSymlink | 0777},
+			{name: "repo/y", content: "x/..", mode: os.ModeSymlink | 0777},
+		}},
+		{"symlink through later symlink", []testZipEntry{
+			{name: "repo/y", content: "x/..", mode: os.ModeSymlink | 0777},
+			{name: "repo/x", content: ".", mode: os.ModeSymlink | 0777},
+		}},
+		{"symlink through replaced directory", []testZipEntry{
+			{name: "repo/x/", mode: os.ModeDir | 0755},
+			{name: "repo/y", content: "x/..", mode: os.ModeSymlink | 0777},
+			{name: "repo/x", content: ".", mode: os.ModeSymlink | 0777},
+		}},
+	}
+
+	for _, test := range tests {
+		directory := t.TempDir()
+		zipFilename := filepath.Join(directory, "crafted.zip")
+		writeCraftedZip(t, zipFilename, test.entries)
+
+		targetDirectory := filepath.Join(directory, "deps/piot/repo")
+		err := extractArchive(zipFilename, targetDirectory, "repo/")
+		if err == nil {
+			t.Errorf("%v: expected error", test.description)
+		}
+
+		if _, statErr := os.Lstat(filepath.Join(directory, "deps/escaped.txt")); statErr == nil {
+			t.Errorf("%v: file was written outside of target", test.description)
+		}
+	}
+}
+
+func TestExtractSymlinkInside(t *testing.T) {
+	directory := t.TempDir()
+	zipFilename := filepath.Join(directory, "crafted.zip")
+	writeCraftedZip(t, zipFilename, []testZipEntry{
+		{name: "repo/src/include/", mode: os.ModeDir | 0755},
+		{name: "repo/src/include/tiny.h", content: "header"},
+		{name: "repo/include", content: "src/include", mode: os.ModeSymlink | 0777},
+	})
+
+	targetDirectory := filepath.Join(directory, "deps/piot/repo")
+	if err := extractArchive(zipFilename, targetDirectory, "repo/"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, readErr := ioutil.ReadFile(filepath.Join(targetDirectory, "include/tiny.h"))
+	if readErr != nil || string(content) != "header" {
+		t.Errorf("symlink should resolve inside target %v '%s'", readErr, content)
+	}
+}
+
+func TestExtractSizeLimit(t *testing.T) {
+	directory := t.TempDir()
+	zipFilename := filepath.Join(directory, "crafted.zip")
+	writeCraftedZip(t, zipFilename, []testZipEntry{
+		{name: "repo/first.txt", content: strings.Repeat("a", 600)},
+		{name: "repo/second.txt", content: strings.Repeat("b", 600)},
+	})
+
+	err := extractArchiveWithLimit(zipFilename, filepath.Join(directory, "target"), "repo/", 1000)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected size limit error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// requiredDependencies are the dependencies that are not optional. Optional dependencies are only fetched when a
+// feature of the root package enables them.
+func (c *Config) requiredDependencies() []Package {
+	var required []Package
+	for _, dep := range c.Dependencies {
+		if !dep.Optional {
+			required = append(required, dep)
+		}
+	}
+	return required
+}
+
+func (c *Config) findDependency(name string) (int, bool) {
+	for index, dep := range c.Dependencies {
+		if dep.Name == name {
+			return index, true
+		}
+	}
+	return -1, false
+}
+
+// validateFeatures checks that features only enable optional dependencies or other features.
+func (c *Config) validateFeatures() error {
+	for feature, enables := range c.Features {
+		for _, name := range enables {
+			if _, isFeature := c.Features[name]; isFeature {
+				continue
+			}
+			index, isDependency := c.findDependency(name)
+			if !isDependency {
+				return fmt.Errorf("feature '%v' enables '%v', which is not a feature or a dependency", feature, name)
+			}
+			if !c.Dependencies[index].Optional {
+				return fmt.Errorf("feature '%v' enables '%v', which is not optional", feature, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) enableFeature(feature string, enabled map[string]bool, enabledDependencies map[string]bool) {
+	if enabled[feature] {
+		return
+	}
+	enabled[feature] = true
+	for _, name := range c.Features[feature] {
+		if _, isFeature := c.Features[name]; isFeature {
+			c.enableFeature(name, enabled, enabledDependencies)
+			continue
+		}
+		enabledDependencies[name] = true
+	}
+}
+
+// withFeatures returns a copy of the config where the optional dependencies of the features are required. It also
+// returns the features that this config does not have.
+func (c *Config) withFeatures(features []string) (*Config, []string) {
+	enabled := make(map[string]bool)
+	enabledDependencies := make(map[string]bool)
+	var unknown []string
+	for _, feature := range features {
+		if _, wasFound := c.Features[feature]; !wasFound {
+			unknown = append(unknown, feature)
+			continue
+		}
+		c.enableFeature(feature, enabled, enabledDependencies)
+	}
+
+	featureConf := *c
+	featureConf.Dependencies = nil
+	for _, dep := range c.Dependencies {
+		if enabledDependencies[dep.Name] {
+			dep.Optional = false
+		}
+		featureConf.Dependencies = append(featureConf.Dependencies, dep)
+	}
+
+	return &featureConf, unknown
+}
+
+func unknownFeaturesError(filename string, unknown []string) error {
+	sort.Strings(unknown)
+	return fmt.Errorf("%v: unknown features %v", filename, strings.Join(unknown, ", "))
+}
+
+// FeatureDefine is the name of the preprocessor define for an enabled feature, e.g. "DEPS_FEATURE_VULKAN".
+func FeatureDefine(feature string) string {
+	var builder strings.Builder
+	builder.WriteString("DEPS_FEATURE_")
+	for _, r := range strings.ToUpper(feature) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			builder.WriteRune(r)
+		} else {
+			builder.WriteRune('_')
+		}
+	}
+	return builder.String()
+}
+
+// enableWorkspaceFeatures enables the features in every member that has them. A feature must be in at least one member.
+func enableWorkspaceFeatures(workspaceFilename string, members []*Config, features []string) error {
+	unknownCount := make(map[string]int)
+	for index, member := range members {
+		var unknown []string
+		members[index], unknown = member.withFeatures(features)
+		for _, feature := range unknown {
+			unknownCount[feature]++
+		}
+	}
+
+	var unknown []string
+	for feature, count := range unknownCount {
+		if count == len(members) {
+			unknown = append(unknown, feature)
+		}
+	}
+	if len(unknown) > 0 {
+		return unknownFeaturesError(workspaceFilename, unknown)
+	}
+	return nil
+}
+
+func sortedFeatures(features []string) []string {
+	seen := make(map[string]bool)
+	sorted := []string{}
+	for _, feature := range features {
+		if !seen[feature] {
+			seen[feature] = true
+			sorted = append(sorted, feature)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,89 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFeatureTestPackages(t *testing.T) string {
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+
+[features]
+vulkan = ["piot/burst-vulkan"]
+gpu = ["vulkan"]
+
+[[dependencies]]
+name = "piot/base"
+version = "*"
+
+[[dependencies]]
+name = "piot/burst-vulkan"
+version = "*"
+optional = true
+`)
+	writeTestPackage(t, rootPath, "piot/base", testPackageContent("piot/base"))
+	writeTestPackage(t, rootPath, "piot/burst-vulkan", testPackageContent("piot/burst-vulkan"))
+	return rootPath
+}
+
+func TestFeatures(t *testing.T) {
+	isolateTestEnvironment(t)
+	rootPath := writeFeatureTestPackages(t)
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+
+	info, err := SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := sortedNodeNames(info.RootNodes); len(names) != 1 || names[0] != "piot/base" {
+		t.Errorf("optional dependency should not be used without features %v", names)
+	}
+
+	info, err = SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath, Features: []string{"gpu"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := sortedNodeNames(info.RootNodes); len(names) != 2 || names[1] != "piot/burst-vulkan" {
+		t.Errorf("feature should enable the optional dependency %v", names)
+	}
+	if len(info.Features) != 1 || info.Features[0] != "gpu" {
+		t.Errorf("wrong features %v", info.Features)
+	}
+
+	_, err = SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath, Features: []string{"metal"}})
+	if err == nil || !strings.Contains(err.Error(), "metal") {
+		t.Errorf("expected unknown feature error, got %v", err)
+	}
+}
+
+func TestFeatureMustEnableOptionalDependency(t *testing.T) {
+	_, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+
+[features]
+base = ["piot/base"]
+
+[[dependencies]]
+name = "piot/base"
+version = "*"
+`))
+	if err == nil {
+		t.Errorf("expected error for feature that enables a required dependency")
+	}
+}
+
+func TestFeatureDefine(t *testing.T) {
+	if define := FeatureDefine("burst-vulkan"); define != "DEPS_FEATURE_BURST_VULKAN" {
+		t.Errorf("wrong define %v", define)
+	}
+}
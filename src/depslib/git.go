@@ -0,0 +1,188 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// GitRemote is the protocol that is used to clone from github and http git servers.
+type GitRemote uint8
+
+const (
+	HTTPSRemote GitRemote = iota
+	SSHRemote
+)
+
+func (r GitRemote) String() string {
+	if r == SSHRemote {
+		return "ssh"
+	}
+	return "https"
+}
+
+// GitBackend selects if Clone mode runs the git executable or uses the built in git implementation.
+type GitBackend uint8
+
+const (
+	ExecGitBackend GitBackend = iota
+	NativeGitBackend
+)
+
+func (b GitBackend) String() string {
+	if b == NativeGitBackend {
+		return "native"
+	}
+	return "exec"
+}
+
+// GitOptions are used in Clone mode. A Depth above zero makes shallow clones that only fetch what is checked out.
+type GitOptions struct {
+	Depth   int
+	Remote  GitRemote
+	Backend GitBackend
+}
+
+// runGit returns the trimmed standard output, or an error with the exit status and standard error of git.
+func runGit(directory string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = directory
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v in '%v' failed: %v: %v", args[0], directory, err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+func gitClone(depsPath string, repoName string, source Source, shortName string, remote GitRemote) error {
+	downloadURL, urlErr := source.CloneURL(repoName, remote)
+	if urlErr != nil {
+		return urlErr
+	}
+
+	log.Printf("git clone from '%v' to %v\n", downloadURL.Redacted(), shortName)
+	_, err := runGit(depsPath, "clone", "--quiet", downloadURL.String(), shortName)
+
+	return err
+}
+
+func gitPull(targetDirectory string, repoName string) error {
+	log.Printf("git pull %v %v\n", repoName, targetDirectory)
+	_, err := runGit(targetDirectory, "pull", "--quiet")
+	return err
+}
+
+func gitFetch(targetDirectory string, repoName string) error {
+	log.Printf("git fetch %v %v\n", repoName, targetDirectory)
+	_, err := runGit(targetDirectory, "fetch", "--quiet")
+	return err
+}
+
+func gitCheckout(targetDirectory string, commit string) error {
+	log.Printf("git checkout %v in %v\n", commit, targetDirectory)
+	_, err := runGit(targetDirectory, "checkout", "--quiet", commit)
+	return err
+}
+
+func gitHeadCommit(targetDirectory string) (string, error) {
+	return runGit(targetDirectory, "rev-parse", "HEAD")
+}
+
+// gitShallowFetch fetches only the wanted commit, tag or branch into a new or existing repository
+// and checks it out.
+func gitShallowFetch(targetDirectory string, repoName string, source Source, wanted string, options GitOptions) error {
+	downloadURL, urlErr := source.CloneURL(repoName, options.Remote)
+	if urlErr != nil {
+		return urlErr
+	}
+
+	if !directoryExists(path.Join(targetDirectory, ".git")) {
+		if _, err := runGit(targetDirectory, "init", "--quiet"); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("git fetch --depth %v '%v' from '%v'\n", options.Depth, wanted, downloadURL.Redacted())
+	if _, err := runGit(targetDirectory, "fetch", "--quiet", "--depth", strconv.Itoa(options.Depth), downloadURL.String(), wanted); err != nil {
+		return err
+	}
+
+	return gitCheckout(targetDirectory, "FETCH_HEAD")
+}
+
+func cloneOrPullRepo(targetDirectory string, depsPath string, shortName string, target fetchTarget) (revision, error) {
+	if target.git.Backend == NativeGitBackend {
+		return nativeCloneOrPullRepo(targetDirectory, target)
+	}
+
+	repoName := target.name
+	ref := target.ref
+	locked := target.locked
+
+	checkoutName := ref.checkoutName()
+	if locked != nil && locked.Commit != "" {
+		checkoutName = locked.Commit
+	}
+
+	if target.git.Depth > 0 {
+		wanted := checkoutName
+		if wanted == "" {
+			wanted = "HEAD"
+		}
+		if err := gitShallowFetch(targetDirectory, repoName, target.source, wanted, target.git); err != nil {
+			return revision{}, err
+		}
+	} else if err := cloneOrPullFullRepo(targetDirectory, depsPath, shortName, checkoutName, target); err != nil {
+		return revision{}, err
+	}
+
+	commit, commitErr := gitHeadCommit(targetDirectory)
+	if commitErr != nil {
+		return revision{}, commitErr
+	}
+
+	return revision{commit: commit}, nil
+}
+
+func cloneOrPullFullRepo(targetDirectory string, depsPath string, shortName string, checkoutName string, target fetchTarget) error {
+	repoName := target.name
+	existingClone := directoryExists(path.Join(targetDirectory, ".git"))
+
+	var err error
+	if existingClone {
+		err = gitFetch(targetDirectory, repoName)
+	} else {
+		err = gitClone(depsPath, repoName, target.source, shortName, target.git.Remote)
+	}
+	if err != nil {
+		return err
+	}
+
+	if checkoutName != "" {
+		if checkoutErr := gitCheckout(targetDirectory, checkoutName); checkoutErr != nil {
+			return checkoutErr
+		}
+	}
+
+	followsBranch := target.ref.Kind == DefaultBranch || target.ref.Kind == Branch
+	if existingClone && target.locked == nil && followsBranch {
+		return gitPull(targetDirectory, repoName)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const nativeRevisionReference = "refs/deps/revision"
+
+func openOrInitNativeRepo(targetDirectory string) (*git.Repository, error) {
+	repo, openErr := git.PlainOpen(targetDirectory)
+	if errors.Is(openErr, git.ErrRepositoryNotExists) {
+		return git.PlainInit(targetDirectory, false)
+	}
+	return repo, openErr
+}
+
+// nativeOrigin returns the origin remote, and makes sure that it points to the clone URL.
+func nativeOrigin(repo *git.Repository, cloneURL string) (*git.Remote, error) {
+	remote, remoteErr := repo.Remote(git.DefaultRemoteName)
+	if remoteErr == nil && len(remote.Config().URLs) == 1 && remote.Config().URLs[0] == cloneURL {
+		return remote, nil
+	}
+	if remoteErr == nil {
+		if err := repo.DeleteRemote(git.DefaultRemoteName); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(remoteErr, git.ErrRemoteNotFound) {
+		return nil, remoteErr
+	}
+
+	return repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{cloneURL}})
+}
+
+// nativeDefaultBranch asks the remote which branch HEAD points to.
+func nativeDefaultBranch(remote *git.Remote) (plumbing.ReferenceName, error) {
+	refs, listErr := remote.List(&git.ListOptions{})
+	if listErr != nil {
+		return "", listErr
+	}
+
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return "", fmt.Errorf("remote '%v' has no HEAD", remote.Config().URLs[0])
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target(), nil
+	}
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head.Hash() {
+			return ref.Name(), nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find the default branch of '%v'", remote.Config().URLs[0])
+}
+
+// nativeRefSpec returns what to fetch for the ref or locked commit, and the local reference it is fetched to.
+func nativeRefSpec(remote *git.Remote, target fetchTarget) (config.RefSpec, string, error) {
+	commit := ""
+	if target.locked != nil && target.locked.Commit != "" {
+		commit = target.locked.Commit
+	} else if target.ref.Kind == Revision {
+		commit = target.ref.Name
+	}
+	if commit != "" {
+		if !isCommitHash(commit) {
+			return "", "", fmt.Errorf("the native git backend needs the full revision of '%v', not '%v'", target.name, commit)
+		}
+		return config.RefSpec(commit + ":" + nativeRevisionReference), commit, nil
+	}
+
+	var remoteName plumbing.ReferenceName
+	switch target.ref.Kind {
+	case Tag:
+		tagName := plumbing.NewTagReferenceName(target.ref.Name)
+		return config.RefSpec(fmt.Sprintf("+%v:%v", tagName, tagName)), tagName.String(), nil
+	case Branch:
+		remoteName = plumbing.NewBranchReferenceName(target.ref.Name)
+	default:
+		var defaultErr error
+		remoteName, defaultErr = nativeDefaultBranch(remote)
+		if defaultErr != nil {
+			return "", "", defaultErr
+		}
+	}
+
+	localName := plumbing.NewRemoteReferenceName(git.DefaultRemoteName, remoteName.Short())
+	return config.RefSpec(fmt.Sprintf("+%v:%v", remoteName, localName)), localName.String(), nil
+}
+
+// nativeCloneOrPullRepo is cloneOrPullRepo without the git executable. It only fetches the ref that is
+// checked out, and leaves HEAD detached at it.
+func nativeCloneOrPullRepo(targetDirectory string, target fetchTarget) (revision, error) {
+	cloneURL, urlErr := target.source.CloneURL(target.name, target.git.Remote)
+	if urlErr != nil {
+		return revision{}, urlErr
+	}
+
+	repo, repoErr := openOrInitNativeRepo(targetDirectory)
+	if repoErr != nil {
+		return revision{}, fmt.Errorf("'%v': %w", targetDirectory, repoErr)
+	}
+
+	remote, remoteErr := nativeOrigin(repo, cloneURL.String())
+	if remoteErr != nil {
+		return revision{}, remoteErr
+	}
+
+	refSpec, localName, refSpecErr := nativeRefSpec(remote, target)
+	if refSpecErr != nil {
+		return revision{}, fmt.Errorf("'%v' from '%v': %w", target.name, cloneURL.Redacted(), refSpecErr)
+	}
+
+	_, alreadyFetchedErr := repo.CommitObject(plumbing.NewHash(refSpec.Src()))
+	if !refSpec.IsExactSHA1() || alreadyFetchedErr != nil {
+		log.Printf("fetch '%v' from '%v'\n", refSpec, cloneURL.Redacted())
+		fetchErr := remote.Fetch(&git.FetchOptions{RefSpecs: []config.RefSpec{refSpec}, Depth: target.git.Depth, Tags: git.NoTags})
+		if fetchErr != nil && !errors.Is(fetchErr, git.NoErrAlreadyUpToDate) {
+			return revision{}, fmt.Errorf("fetch '%v' from '%v' failed: %w", target.name, cloneURL.Redacted(), fetchErr)
+		}
+	}
+
+	hash, resolveErr := repo.ResolveRevision(plumbing.Revision(localName))
+	if resolveErr != nil {
+		return revision{}, fmt.Errorf("could not resolve '%v' in '%v': %w", localName, targetDirectory, resolveErr)
+	}
+
+	worktree, worktreeErr := repo.Worktree()
+	if worktreeErr != nil {
+		return revision{}, worktreeErr
+	}
+	log.Printf("checkout %v in %v\n", hash, targetDirectory)
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return revision{}, fmt.Errorf("checkout %v in '%v' failed: %w", hash, targetDirectory, err)
+	}
+
+	head, headErr := repo.Head()
+	if headErr != nil {
+		return revision{}, headErr
+	}
+
+	return revision{commit: head.Hash().String()}, nil
+}
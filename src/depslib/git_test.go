@@ -0,0 +1,152 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runTestGit(t *testing.T, directory string, args ...string) string {
+	args = append([]string{"-c", "user.name=deps", "-c", "user.email=deps@example.com", "-c", "commit.gpgsign=false"}, args...)
+	output, err := runGit(directory, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+// newTestGitRemote creates a bare repository for piot/thunder with version 1.0.0 tagged as v1,
+// and version 1.1.0 on master. It returns the source and the commit of v1.
+func newTestGitRemote(t *testing.T) (string, string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	remoteDirectory := t.TempDir()
+	bareDirectory := filepath.Join(remoteDirectory, "piot/thunder.git")
+	writeTestFile(t, filepath.Join(bareDirectory, ".keep"), "")
+	runTestGit(t, bareDirectory, "init", "--quiet", "--bare")
+	runTestGit(t, bareDirectory, "symbolic-ref", "HEAD", "refs/heads/master")
+	runTestGit(t, bareDirectory, "config", "uploadpack.allowAnySHA1InWant", "true")
+
+	workDirectory := t.TempDir()
+	runTestGit(t, workDirectory, "init", "--quiet")
+	for _, version := range []string{"1.0.0", "1.1.0"} {
+		writeTestFile(t, filepath.Join(workDirectory, "deps.toml"), "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \""+version+"\"\n")
+		runTestGit(t, workDirectory, "add", "deps.toml")
+		runTestGit(t, workDirectory, "commit", "--quiet", "-m", version)
+		if version == "1.0.0" {
+			runTestGit(t, workDirectory, "tag", "v1")
+		}
+	}
+	runTestGit(t, workDirectory, "push", "--quiet", "--tags", bareDirectory, "HEAD:refs/heads/master")
+
+	return "file://" + filepath.ToSlash(remoteDirectory) + "/{name}", runTestGit(t, workDirectory, "rev-parse", "v1^{commit}")
+}
+
+func setupTestClone(t *testing.T, source string, refLine string, git GitOptions) (*DependencyInfo, string, error) {
+	isolateTestEnvironment(t)
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+source+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+`+refLine+`
+`)
+	info, err := SetupDependencies(filepath.Join(rootPath, "piot/app/deps.toml"), SetupOptions{Mode: Clone, Git: git})
+	return info, filepath.Join(rootPath, "piot/app/deps/piot/thunder"), err
+}
+
+func TestCloneFromBareRepo(t *testing.T) {
+	source, tagCommit := newTestGitRemote(t)
+
+	for _, git := range []GitOptions{{}, {Depth: 1}, {Backend: NativeGitBackend}, {Backend: NativeGitBackend, Depth: 1}} {
+		info, directory, err := setupTestClone(t, source, `tag = "v1"`, git)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thunder := info.RootNodes[0]
+		if thunder.Version().String() != "1.0.0" || thunder.Commit() != tagCommit {
+			t.Errorf("%v depth %v: expected v1 at %v, got %v at %v", git.Backend, git.Depth, tagCommit, thunder.Version(), thunder.Commit())
+		}
+		if git.Depth > 0 {
+			if count := runTestGit(t, directory, "rev-list", "--count", "HEAD"); count != "1" {
+				t.Errorf("shallow clone should only have one commit, has %v", count)
+			}
+		}
+	}
+}
+
+func TestShallowCloneBranchAndRevision(t *testing.T) {
+	source, tagCommit := newTestGitRemote(t)
+
+	for _, backend := range []GitBackend{ExecGitBackend, NativeGitBackend} {
+		git := GitOptions{Depth: 1, Backend: backend}
+		info, _, err := setupTestClone(t, source, "", git)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version := info.RootNodes[0].Version().String(); version != "1.1.0" {
+			t.Errorf("%v: default branch should be 1.1.0, got %v", backend, version)
+		}
+
+		info, _, err = setupTestClone(t, source, `rev = "`+tagCommit+`"`, git)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit := info.RootNodes[0].Commit(); commit != tagCommit {
+			t.Errorf("%v: expected revision %v, got %v", backend, tagCommit, commit)
+		}
+	}
+}
+
+func TestCloneFailureIsReported(t *testing.T) {
+	source, _ := newTestGitRemote(t)
+	source = strings.Replace(source, "{name}", "{owner}/missing-{repo}", 1)
+
+	for _, git := range []GitOptions{{}, {Depth: 1}, {Backend: NativeGitBackend}} {
+		_, _, err := setupTestClone(t, source, "", git)
+		if err == nil || !strings.Contains(err.Error(), "missing-thunder") {
+			t.Errorf("%v depth %v: expected git error that mentions the repository, got %v", git.Backend, git.Depth, err)
+		}
+	}
+}
+
+func TestNativeFetchIntoExistingClone(t *testing.T) {
+	source, tagCommit := newTestGitRemote(t)
+	git := GitOptions{Backend: NativeGitBackend}
+
+	_, directory, err := setupTestClone(t, source, "", git)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configFilename := filepath.Join(directory, "../../../deps.toml")
+	writeTestPackage(t, filepath.Dir(filepath.Dir(filepath.Dir(configFilename))), "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+source+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	info, err := SetupDependencies(configFilename, SetupOptions{Mode: Clone, Update: true, Git: git})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit := info.RootNodes[0].Commit(); commit != tagCommit {
+		t.Errorf("expected existing clone to be checked out at %v, got %v", tagCommit, commit)
+	}
+}
@@ -0,0 +1,107 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type GraphNode struct {
+	Name            string   `json:"name"`
+	Version         string   `json:"version"`
+	ArtifactType    string   `json:"artifactType"`
+	Source          string   `json:"source"`
+	Ref             string   `json:"ref"`
+	Commit          string   `json:"commit"`
+	Dependencies    []string `json:"dependencies"`
+	DevDependencies []string `json:"devDependencies"`
+	DependingOnThis []string `json:"dependingOnThis"`
+	// DevOnly is true for nodes that are only needed by development dependencies.
+	DevOnly bool `json:"devOnly"`
+}
+
+// Graph is the resolved dependency graph in a form that can be written as JSON or DOT.
+type Graph struct {
+	Root  string      `json:"root"`
+	Nodes []GraphNode `json:"nodes"`
+}
+
+func nodeNames(nodes []*DependencyNode) []string {
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.name)
+	}
+	return names
+}
+
+func sourceName(node *DependencyNode) string {
+	if node.path != "" {
+		return "path:" + node.path
+	}
+	if node.source == "" {
+		return "github"
+	}
+	return node.source
+}
+
+func NewGraph(info *DependencyInfo) *Graph {
+	devOnly := make(map[*DependencyNode]bool)
+	for _, node := range info.DevelopmentNodes {
+		devOnly[node] = true
+	}
+
+	allNodes := append(append([]*DependencyNode{}, info.RootNodes...), info.DevelopmentNodes...)
+	sort.Slice(allNodes, func(i, j int) bool {
+		return allNodes[i].name < allNodes[j].name
+	})
+	allNodes = append([]*DependencyNode{info.RootNode}, allNodes...)
+
+	graph := &Graph{Root: info.RootNode.name}
+	for _, node := range allNodes {
+		dependingOnThis := nodeNames(node.dependingOnThis)
+		sort.Strings(dependingOnThis)
+		graph.Nodes = append(graph.Nodes, GraphNode{Name: node.name, Version: node.version.String(),
+			ArtifactType: node.artifactType.String(), Source: sourceName(node), Ref: node.ref.String(),
+			Commit: node.commit, Dependencies: nodeNames(node.dependencies), DevDependencies: nodeNames(node.development),
+			DependingOnThis: dependingOnThis, DevOnly: devOnly[node]})
+	}
+
+	return graph
+}
+
+func (g *Graph) WriteJSON(writer io.Writer) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
+
+func (g *Graph) WriteDot(writer io.Writer) error {
+	var builder strings.Builder
+	builder.WriteString("digraph deps {\n")
+	for _, node := range g.Nodes {
+		label := fmt.Sprintf("%v\\n%v", node.Name, node.Version)
+		if node.Ref != "" {
+			label += "\\n" + node.Ref
+		}
+		fmt.Fprintf(&builder, "  %q [label=\"%v\"];\n", node.Name, label)
+	}
+	for _, node := range g.Nodes {
+		for _, dependency := range node.Dependencies {
+			fmt.Fprintf(&builder, "  %q -> %q;\n", node.Name, dependency)
+		}
+		for _, dependency := range node.DevDependencies {
+			fmt.Fprintf(&builder, "  %q -> %q [style=dashed, label=\"dev\"];\n", node.Name, dependency)
+		}
+	}
+	builder.WriteString("}\n")
+
+	_, err := io.WriteString(writer, builder.String())
+	return err
+}
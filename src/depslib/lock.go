@@ -0,0 +1,122 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+const lockFileVersion = "0.0.0"
+
+type LockedPackage struct {
+	Name     string `toml:"name"`
+	Version  string `toml:"version"`
+	Ref      string `toml:"ref,omitempty"`
+	Commit   string `toml:"commit"`
+	Checksum string `toml:"checksum,omitempty"`
+}
+
+func (p LockedPackage) String() string {
+	return fmt.Sprintf("name:%v version:%v commit:%v", p.Name, p.Version, p.Commit)
+}
+
+type LockFile struct {
+	LockVersion string          `toml:"lockversion"`
+	Packages    []LockedPackage `toml:"package"`
+}
+
+func NewLockFile() *LockFile {
+	return &LockFile{LockVersion: lockFileVersion}
+}
+
+func LockFilenameFromConfigFilename(configFilename string) string {
+	return filepath.Join(filepath.Dir(configFilename), "deps.lock")
+}
+
+func (l *LockFile) Find(name string) *LockedPackage {
+	if l == nil {
+		return nil
+	}
+	for index := range l.Packages {
+		if l.Packages[index].Name == name {
+			return &l.Packages[index]
+		}
+	}
+	return nil
+}
+
+func ReadLockFromReader(reader io.Reader) (*LockFile, error) {
+	tomlString, readErr := ioutil.ReadAll(reader)
+	if readErr != nil {
+		return nil, readErr
+	}
+	lockFile := &LockFile{}
+	if unmarshalErr := toml.Unmarshal(tomlString, lockFile); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	if lockFile.LockVersion != lockFileVersion {
+		return nil, fmt.Errorf("wrong lock file format version '%v'", lockFile.LockVersion)
+	}
+	return lockFile, nil
+}
+
+// ReadLockFile returns nil, without an error, if there is no lock file.
+func ReadLockFile(filename string) (*LockFile, error) {
+	reader, openErr := os.Open(filename)
+	if os.IsNotExist(openErr) {
+		return nil, nil
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer reader.Close()
+	return ReadLockFromReader(reader)
+}
+
+func WriteLockFile(filename string, lockFile *LockFile) error {
+	sort.Slice(lockFile.Packages, func(i, j int) bool {
+		return lockFile.Packages[i].Name < lockFile.Packages[j].Name
+	})
+
+	var buffer bytes.Buffer
+	buffer.WriteString("# generated by deps, do not edit\n")
+	encoder := toml.NewEncoder(&buffer).Order(toml.OrderPreserve).Indentation("")
+	if len(lockFile.Packages) == 0 {
+		// go-toml drops all keys when preserving the order of a struct without any packages
+		encoder = toml.NewEncoder(&buffer)
+	}
+	if encodeErr := encoder.Encode(lockFile); encodeErr != nil {
+		return encodeErr
+	}
+
+	return ioutil.WriteFile(filename, buffer.Bytes(), 0644)
+}
+
+func lockFileFromCache(cache *Cache, rootNode *DependencyNode) *LockFile {
+	lockFile := NewLockFile()
+	for _, node := range cache.Nodes {
+		if cache.Overrides.Find(node.name) != nil {
+			if locked := cache.Lock.Find(node.name); locked != nil {
+				lockFile.Packages = append(lockFile.Packages, *locked)
+			}
+			continue
+		}
+		if node == rootNode || node.local {
+			continue
+		}
+		lockFile.Packages = append(lockFile.Packages, LockedPackage{Name: node.name, Version: node.version.String(),
+			Ref: node.ref.String(), Commit: node.commit, Checksum: node.checksum})
+	}
+	return lockFile
+}
@@ -0,0 +1,183 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockFileRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deps.lock")
+
+	lockFile := NewLockFile()
+	lockFile.Packages = append(lockFile.Packages,
+		LockedPackage{Name: "piot/tiny-clib", Version: "0.1.0", Commit: "3b5d5c3712955042212316173ccf37be800a6d8c", Checksum: "abc"},
+		LockedPackage{Name: "piot/thunder", Version: "1.2.0", Commit: "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"},
+	)
+
+	if err := WriteLockFile(filename, lockFile); err != nil {
+		t.Fatal(err)
+	}
+
+	readLockFile, readErr := ReadLockFile(filename)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	if len(readLockFile.Packages) != 2 {
+		t.Fatalf("wrong package count %d", len(readLockFile.Packages))
+	}
+
+	if readLockFile.Packages[0].Name != "piot/thunder" {
+		t.Errorf("packages should be sorted by name, got %v", readLockFile.Packages[0])
+	}
+
+	locked := readLockFile.Find("piot/tiny-clib")
+	if locked == nil || locked.Checksum != "abc" || locked.Commit != "3b5d5c3712955042212316173ccf37be800a6d8c" {
+		t.Errorf("wrong locked package %v", locked)
+	}
+}
+
+func TestEmptyLockFileRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "deps.lock")
+
+	if err := WriteLockFile(filename, NewLockFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	readLockFile, readErr := ReadLockFile(filename)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+
+	if len(readLockFile.Packages) != 0 {
+		t.Errorf("expected no packages, got %v", readLockFile.Packages)
+	}
+}
+
+func TestMissingLockFile(t *testing.T) {
+	lockFile, err := ReadLockFile(filepath.Join(t.TempDir(), "deps.lock"))
+	if err != nil || lockFile != nil {
+		t.Errorf("expected no lock file and no error, got %v %v", lockFile, err)
+	}
+
+	if lockFile.Find("piot/thunder") != nil {
+		t.Errorf("nil lock file should not find anything")
+	}
+}
+
+// writeLockedTestApp writes an app that depends on piot/thunder at v1 and returns its deps.toml filename.
+func writeLockedTestApp(t *testing.T, env *testEnvironment) string {
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+env.source()+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lockFile, readErr := ReadLockFile(LockFilenameFromConfigFilename(configFilename))
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if locked := lockFile.Find("piot/thunder"); locked == nil || locked.Commit != testCommit || locked.Checksum == "" {
+		t.Fatalf("wrong locked package %v", locked)
+	}
+
+	return configFilename
+}
+
+func TestLockedFetchFromDownloadCache(t *testing.T) {
+	env := newTestEnvironment(t)
+	configFilename := writeLockedTestApp(t, env)
+
+	if err := os.RemoveAll(filepath.Join(filepath.Dir(configFilename), "deps")); err != nil {
+		t.Fatal(err)
+	}
+	// without the refs the archive can only be found by the locked checksum
+	if err := os.RemoveAll(filepath.Join(os.Getenv("DEPS_CACHE_DIR"), "refs")); err != nil {
+		t.Fatal(err)
+	}
+
+	requestCount := env.requestCount.Load()
+	if _, err := SetupDependencies(configFilename, SetupOptions{Offline: true}); err != nil {
+		t.Fatal(err)
+	}
+	if env.requestCount.Load() != requestCount {
+		t.Errorf("locked archive should come from the download cache, %d requests", env.requestCount.Load()-requestCount)
+	}
+}
+
+func TestLockedFetchWithoutDownloadCache(t *testing.T) {
+	env := newTestEnvironment(t)
+	configFilename := writeLockedTestApp(t, env)
+
+	if err := os.RemoveAll(filepath.Join(filepath.Dir(configFilename), "deps")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DEPS_CACHE_DIR", t.TempDir())
+
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(filepath.Dir(configFilename), "deps/piot/thunder/deps.toml"), "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.0.0\"\n")
+}
+
+func TestLockedFetchOfMovedBranch(t *testing.T) {
+	isolateTestEnvironment(t)
+	serverDirectory := t.TempDir()
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDirectory)))
+	defer server.Close()
+
+	thunderConfig := "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.0.0\"\n"
+	writeTestCommitZip(t, filepath.Join(serverDirectory, "thunder-master.zip"), testCommit, map[string]string{
+		"thunder/deps.toml": thunderConfig,
+	})
+
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+server.URL+`/{repo}-{ref}.zip"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+branch = "master"
+`)
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// master has moved on, but the locked commit can still be downloaded by itself
+	writeTestCommitZip(t, filepath.Join(serverDirectory, "thunder-master.zip"), "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", map[string]string{
+		"thunder/deps.toml": "moved",
+	})
+	writeTestCommitZip(t, filepath.Join(serverDirectory, "thunder-"+testCommit+".zip"), testCommit, map[string]string{
+		"thunder-" + testCommit + "/deps.toml": thunderConfig,
+	})
+	if err := os.RemoveAll(filepath.Join(rootPath, "piot/app/deps")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	checkTestFile(t, filepath.Join(rootPath, "piot/app/deps/piot/thunder/deps.toml"), thunderConfig)
+}
@@ -0,0 +1,139 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// OverrideFilename is a file next to deps.toml that should not be committed. It replaces where some packages
+// come from, e.g. a local checkout:
+//
+//	[[override]]
+//	name = "piot/tiny-clib"
+//	path = "../tiny-clib"
+const OverrideFilename = "deps.override.toml"
+
+type overrideFile struct {
+	Overrides []Package `toml:"override"`
+}
+
+// Overrides are read from the user configuration directory and from deps.override.toml. The project file wins.
+type Overrides struct {
+	packages map[string]Package
+}
+
+// DefaultUserOverrideFilename is override.toml in $DEPS_CONFIG_DIR, or in deps/ in the user configuration directory.
+func DefaultUserOverrideFilename() (string, error) {
+	if directory := os.Getenv("DEPS_CONFIG_DIR"); directory != "" {
+		return filepath.Join(directory, "override.toml"), nil
+	}
+	userConfigDirectory, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userConfigDirectory, "deps", "override.toml"), nil
+}
+
+func expandOverridePath(overrideDirectory string, p string) (string, error) {
+	if strings.HasPrefix(p, "~/") {
+		home, homeErr := os.UserHomeDir()
+		if homeErr != nil {
+			return "", homeErr
+		}
+		return filepath.Join(home, p[2:]), nil
+	}
+	if filepath.IsAbs(p) {
+		return p, nil
+	}
+	return filepath.Join(overrideDirectory, p), nil
+}
+
+func readOverrideFile(filename string) ([]Package, error) {
+	content, readErr := ioutil.ReadFile(filename)
+	if os.IsNotExist(readErr) {
+		return nil, nil
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	file := &overrideFile{}
+	if err := toml.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+
+	for index, override := range file.Overrides {
+		if err := validatePackageRef(override); err != nil {
+			return nil, fmt.Errorf("%v: %w", filename, err)
+		}
+		if override.Path == "" && override.Ref().IsDefault() && override.Source == "" {
+			return nil, fmt.Errorf("%v: override of '%v' needs a path, tag, branch, rev or source", filename, override.Name)
+		}
+		if override.Path != "" {
+			expandedPath, expandErr := expandOverridePath(filepath.Dir(filename), override.Path)
+			if expandErr != nil {
+				return nil, expandErr
+			}
+			file.Overrides[index].Path = expandedPath
+		}
+	}
+
+	return file.Overrides, nil
+}
+
+// ReadOverrides reads the user override file and the deps.override.toml next to the config file.
+func ReadOverrides(userFilename string, configFilename string) (*Overrides, error) {
+	overrides := &Overrides{packages: make(map[string]Package)}
+	for _, filename := range []string{userFilename, filepath.Join(filepath.Dir(configFilename), OverrideFilename)} {
+		if filename == "" {
+			continue
+		}
+		packages, readErr := readOverrideFile(filename)
+		if readErr != nil {
+			return nil, readErr
+		}
+		for _, override := range packages {
+			log.Printf("'%v' is overridden by '%v'\n", override.Name, filename)
+			overrides.packages[override.Name] = override
+		}
+	}
+	return overrides, nil
+}
+
+// Find returns nil if the package is not overridden. It is safe to call on nil.
+func (o *Overrides) Find(name string) *Package {
+	if o == nil {
+		return nil
+	}
+	override, wasFound := o.packages[name]
+	if !wasFound {
+		return nil
+	}
+	return &override
+}
+
+// apply keeps the name and version requirement of the dependency, and takes where it is fetched from from the override.
+func (o *Overrides) apply(dep Package) Package {
+	override := o.Find(dep.Name)
+	if override == nil {
+		return dep
+	}
+
+	overridden := *override
+	overridden.Version = dep.Version
+	if overridden.Source == "" && overridden.Path == "" {
+		overridden.Source = dep.Source
+	}
+	return overridden
+}
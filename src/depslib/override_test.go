@@ -0,0 +1,85 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestOverrideWithLocalCheckout(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	directory := t.TempDir()
+	configFilename := filepath.Join(directory, "app/deps.toml")
+	writeTestFile(t, configFilename, `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "`+env.source()+`"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lockContent, _ := ioutil.ReadFile(filepath.Join(directory, "app/deps.lock"))
+
+	writeTestFile(t, filepath.Join(directory, "thunder-checkout/deps.toml"), "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.3.0\"\n")
+	writeTestFile(t, filepath.Join(env.configDirectory, "override.toml"), `[[override]]
+name = "piot/thunder"
+tag = "v9"
+`)
+	writeTestFile(t, filepath.Join(directory, "app", OverrideFilename), `[[override]]
+name = "piot/thunder"
+path = "../thunder-checkout"
+`)
+
+	env.requestCount.Store(0)
+	info, err := SetupDependencies(configFilename, SetupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	thunder := info.RootNode.Dependencies()[0]
+	if thunder.Path() != filepath.Join(directory, "thunder-checkout") || thunder.Version().String() != "1.3.0" {
+		t.Errorf("expected local checkout, got %v '%v'", thunder, thunder.Path())
+	}
+	if env.requestCount.Load() != 0 {
+		t.Errorf("overridden package should not be downloaded")
+	}
+
+	newLockContent, _ := ioutil.ReadFile(filepath.Join(directory, "app/deps.lock"))
+	if string(newLockContent) != string(lockContent) {
+		t.Errorf("override should not change deps.lock:\n%s\n%s", lockContent, newLockContent)
+	}
+}
+
+func TestOverrideRef(t *testing.T) {
+	directory := t.TempDir()
+	writeTestFile(t, filepath.Join(directory, OverrideFilename), `[[override]]
+name = "piot/thunder"
+branch = "experiment"
+`)
+	overrides, err := ReadOverrides("", filepath.Join(directory, "deps.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dep := overrides.apply(Package{Name: "piot/thunder", Version: "^1.0.0", Tag: "v1", Source: "https://git.internal/{name}"})
+	if dep.Version != "^1.0.0" || dep.Ref() != (Ref{Kind: Branch, Name: "experiment"}) || dep.Source != "https://git.internal/{name}" {
+		t.Errorf("wrong overridden dependency %+v", dep)
+	}
+
+	writeTestFile(t, filepath.Join(directory, OverrideFilename), `[[override]]
+name = "piot/thunder"
+`)
+	if _, err := ReadOverrides("", filepath.Join(directory, "deps.toml")); err == nil {
+		t.Errorf("expected error for override without path or ref")
+	}
+}
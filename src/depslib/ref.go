@@ -0,0 +1,81 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+)
+
+type RefKind uint8
+
+const (
+	DefaultBranch RefKind = iota
+	Tag
+	Branch
+	Revision
+)
+
+// Ref is the git reference a dependency is fetched at.
+type Ref struct {
+	Kind RefKind
+	Name string
+}
+
+func (r Ref) IsDefault() bool {
+	return r.Kind == DefaultBranch
+}
+
+func (r Ref) String() string {
+	switch r.Kind {
+	case Tag:
+		return "tag:" + r.Name
+	case Branch:
+		return "branch:" + r.Name
+	case Revision:
+		return "rev:" + r.Name
+	}
+	return ""
+}
+
+// archiveName is the part of a github archive URL that selects the ref, e.g. "refs/tags/v1.0.0".
+func (r Ref) archiveName() string {
+	switch r.Kind {
+	case Tag:
+		return "refs/tags/" + r.Name
+	case Branch:
+		return "refs/heads/" + r.Name
+	case Revision:
+		return r.Name
+	}
+	return "HEAD"
+}
+
+// checkoutName is what git checkout needs to get to the ref after a clone or fetch.
+func (r Ref) checkoutName() string {
+	switch r.Kind {
+	case Tag:
+		return "tags/" + r.Name
+	case Branch, Revision:
+		return r.Name
+	}
+	return ""
+}
+
+func validatePackageRef(p Package) error {
+	count := 0
+	for _, field := range []string{p.Tag, p.Branch, p.Rev} {
+		if field != "" {
+			count++
+		}
+	}
+	if count > 1 {
+		return fmt.Errorf("dependency '%v' can only have one of tag, branch or rev", p.Name)
+	}
+	if p.Path != "" && (count > 0 || p.Source != "" || p.Sha256 != "" || p.StripPrefix != "") {
+		return fmt.Errorf("dependency '%v' has a path and can not also have tag, branch, rev, source, sha256 or strip_prefix", p.Name)
+	}
+	return nil
+}
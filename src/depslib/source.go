@@ -0,0 +1,167 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Source is where the archives and git repositories of packages are fetched from.
+type Source interface {
+	ArchiveURL(repoName string, ref Ref) (*url.URL, error)
+	CloneURL(repoName string, remote GitRemote) (*url.URL, error)
+}
+
+// GitHubSource fetches from github.com, using the GITHUB_TOKEN environment variable if it is set.
+type GitHubSource struct{}
+
+func (s GitHubSource) ArchiveURL(repoName string, ref Ref) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("https://%vgithub.com/%v/archive/%v.zip", gitRepoPrefix(), repoName, ref.archiveName()))
+}
+
+func (s GitHubSource) CloneURL(repoName string, remote GitRemote) (*url.URL, error) {
+	if remote == SSHRemote {
+		return url.Parse(fmt.Sprintf("ssh://git@github.com/%v.git", repoName))
+	}
+	return url.Parse(fmt.Sprintf("https://%vgithub.com/%v.git", gitRepoPrefix(), repoName))
+}
+
+func (s GitHubSource) String() string {
+	return "github"
+}
+
+// GitHostSource is a self-hosted git server, e.g. "https://git.internal/{name}".
+// Gitea and Gogs use the same archive URLs as github, GitLab has its own.
+type GitHostSource struct {
+	Template string
+	GitLab   bool
+}
+
+func gitHostRefName(ref Ref) string {
+	if ref.IsDefault() {
+		return "HEAD"
+	}
+	return ref.Name
+}
+
+func (s GitHostSource) ArchiveURL(repoName string, ref Ref) (*url.URL, error) {
+	if !isHTTPTemplate(s.Template) {
+		return nil, fmt.Errorf("'%v' can only be cloned from '%v'", repoName, s.Template)
+	}
+	base := expandSourceTemplate(s.Template, repoName, ref)
+	refName := gitHostRefName(ref)
+	if s.GitLab {
+		return url.Parse(fmt.Sprintf("%v/-/archive/%v/%v-%v.zip", base, refName, lastRepoName(repoName), refName))
+	}
+	return url.Parse(fmt.Sprintf("%v/archive/%v.zip", base, refName))
+}
+
+// CloneURL uses the template as is for ssh:// and file:// templates. For http templates and the ssh remote,
+// it clones from ssh://git@ on the same host.
+func (s GitHostSource) CloneURL(repoName string, remote GitRemote) (*url.URL, error) {
+	cloneURL, parseErr := url.Parse(expandSourceTemplate(s.Template, repoName, Ref{}) + ".git")
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if remote == SSHRemote && isHTTPTemplate(s.Template) {
+		cloneURL.Scheme = "ssh"
+		cloneURL.User = url.User("git")
+		cloneURL.Host = cloneURL.Hostname()
+	}
+	return cloneURL, nil
+}
+
+func (s GitHostSource) String() string {
+	if s.GitLab {
+		return "gitlab+" + s.Template
+	}
+	return s.Template
+}
+
+// ArchiveServerSource is a plain HTTP file server, e.g. "https://files.internal/{name}/{ref}.zip".
+// It can not be used in Clone mode.
+type ArchiveServerSource struct {
+	Template string
+}
+
+func (s ArchiveServerSource) ArchiveURL(repoName string, ref Ref) (*url.URL, error) {
+	return url.Parse(expandSourceTemplate(s.Template, repoName, ref))
+}
+
+func (s ArchiveServerSource) CloneURL(repoName string, remote GitRemote) (*url.URL, error) {
+	return nil, fmt.Errorf("'%v' can not be cloned from archive server '%v'", repoName, s.Template)
+}
+
+func (s ArchiveServerSource) String() string {
+	return s.Template
+}
+
+func lastRepoName(repoName string) string {
+	return repoName[strings.LastIndex(repoName, "/")+1:]
+}
+
+func archiveServerRefName(ref Ref) string {
+	if ref.IsDefault() {
+		return "latest"
+	}
+	return ref.Name
+}
+
+// expandSourceTemplate replaces {name}, {owner}, {repo} and {ref} in a source template.
+func expandSourceTemplate(template string, repoName string, ref Ref) string {
+	owner := repoName[:strings.Index(repoName, "/")]
+	replacer := strings.NewReplacer("{name}", repoName, "{owner}", owner, "{repo}", lastRepoName(repoName),
+		"{ref}", archiveServerRefName(ref))
+	return strings.TrimSuffix(replacer.Replace(template), "/")
+}
+
+// ParseSource converts the source field in deps.toml to a Source. An empty string or "github" is github.com,
+// "gitlab+https://..." is a GitLab server, a template with {ref} is an archive server and any other
+// URL template is a git server with github style archive URLs. Git servers can also be ssh:// or file://,
+// but then they can only be used in Clone mode.
+func ParseSource(s string) (Source, error) {
+	if s == "" || s == "github" {
+		return GitHubSource{}, nil
+	}
+
+	template := s
+	isGitLab := strings.HasPrefix(s, "gitlab+")
+	if isGitLab {
+		template = strings.TrimPrefix(s, "gitlab+")
+	}
+	isArchiveServer := !isGitLab && strings.Contains(template, "{ref}")
+	if err := validateSourceTemplate(template, !isArchiveServer); err != nil {
+		return nil, err
+	}
+
+	if isArchiveServer {
+		return ArchiveServerSource{Template: template}, nil
+	}
+
+	return GitHostSource{Template: template, GitLab: isGitLab}, nil
+}
+
+func isHTTPTemplate(template string) bool {
+	return strings.HasPrefix(template, "http://") || strings.HasPrefix(template, "https://")
+}
+
+// validateSourceTemplate only allows ssh:// and file:// for git servers, since those can only be cloned.
+func validateSourceTemplate(template string, isGitServer bool) error {
+	if !strings.Contains(template, "{name}") && !strings.Contains(template, "{repo}") {
+		return fmt.Errorf("source '%v' must contain {name} or {repo}", template)
+	}
+	parsed, parseErr := url.Parse(template)
+	if parseErr != nil {
+		return parseErr
+	}
+	isGitScheme := parsed.Scheme == "ssh" || parsed.Scheme == "file"
+	if parsed.Scheme != "http" && parsed.Scheme != "https" && !(isGitServer && isGitScheme) {
+		return fmt.Errorf("source '%v' has unsupported scheme '%v'", template, parsed.Scheme)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceURLs(t *testing.T) {
+	tests := []struct {
+		source   string
+		ref      Ref
+		expected string
+	}{
+		{"", Ref{Kind: Tag, Name: "v1.0.0"}, "https://github.com/piot/thunder/archive/refs/tags/v1.0.0.zip"},
+		{"https://git.internal/{name}", Ref{}, "https://git.internal/piot/thunder/archive/HEAD.zip"},
+		{"https://git.internal/{name}", Ref{Kind: Branch, Name: "dev"}, "https://git.internal/piot/thunder/archive/dev.zip"},
+		{"gitlab+https://gitlab.internal/{name}", Ref{Kind: Tag, Name: "v2"}, "https://gitlab.internal/piot/thunder/-/archive/v2/thunder-v2.zip"},
+		{"https://files.internal/{owner}/{repo}-{ref}.zip", Ref{}, "https://files.internal/piot/thunder-latest.zip"},
+	}
+
+	os.Unsetenv("GITHUB_TOKEN")
+	for _, test := range tests {
+		source, sourceErr := ParseSource(test.source)
+		if sourceErr != nil {
+			t.Fatal(sourceErr)
+		}
+		archiveURL, urlErr := source.ArchiveURL("piot/thunder", test.ref)
+		if urlErr != nil {
+			t.Fatal(urlErr)
+		}
+		if archiveURL.String() != test.expected {
+			t.Errorf("'%v': expected %v, got %v", test.source, test.expected, archiveURL)
+		}
+	}
+}
+
+func TestCloneURLs(t *testing.T) {
+	tests := []struct {
+		source   string
+		remote   GitRemote
+		expected string
+	}{
+		{"", SSHRemote, "ssh://git@github.com/piot/thunder.git"},
+		{"https://git.internal:8443/{name}", HTTPSRemote, "https://git.internal:8443/piot/thunder.git"},
+		{"https://git.internal:8443/{name}", SSHRemote, "ssh://git@git.internal/piot/thunder.git"},
+		{"ssh://deploy@git.internal:2222/{name}", HTTPSRemote, "ssh://deploy@git.internal:2222/piot/thunder.git"},
+		{"file:///srv/git/{name}", SSHRemote, "file:///srv/git/piot/thunder.git"},
+	}
+
+	for _, test := range tests {
+		source, sourceErr := ParseSource(test.source)
+		if sourceErr != nil {
+			t.Fatal(sourceErr)
+		}
+		cloneURL, urlErr := source.CloneURL("piot/thunder", test.remote)
+		if urlErr != nil {
+			t.Fatal(urlErr)
+		}
+		if cloneURL.String() != test.expected {
+			t.Errorf("'%v': expected %v, got %v", test.source, test.expected, cloneURL)
+		}
+	}
+
+	source, _ := ParseSource("file:///srv/git/{name}")
+	if _, err := source.ArchiveURL("piot/thunder", Ref{}); err == nil {
+		t.Errorf("file:// sources should only be cloneable")
+	}
+}
+
+func TestIllegalSource(t *testing.T) {
+	for _, s := range []string{"https://git.internal/", "ftp://git.internal/{name}", "ssh://files.internal/{name}/{ref}.zip"} {
+		if _, err := ParseSource(s); err == nil {
+			t.Errorf("expected error for source '%v'", s)
+		}
+	}
+
+	source, _ := ParseSource("https://files.internal/{name}/{ref}.zip")
+	if _, err := source.CloneURL("piot/thunder", HTTPSRemote); err == nil {
+		t.Errorf("archive servers should not be cloneable")
+	}
+}
+
+func TestWgetFromArchiveServer(t *testing.T) {
+	serverDirectory := t.TempDir()
+	writeTestZip(t, filepath.Join(serverDirectory, "thunder-v1.zip"), map[string]string{
+		"thunder/deps.toml": "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.0.0\"\n",
+	})
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDirectory)))
+	defer server.Close()
+
+	workingDirectory, _ := os.Getwd()
+	defer os.Chdir(workingDirectory)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	source, sourceErr := ParseSource(server.URL + "/{repo}-{ref}.zip")
+	if sourceErr != nil {
+		t.Fatal(sourceErr)
+	}
+
+	downloads := &DownloadCache{Directory: t.TempDir()}
+	target := fetchTarget{name: "piot/thunder", source: source, ref: Ref{Kind: Tag, Name: "v1"}}
+	fetched, fetchErr := wgetRepo("", "deps", target, downloads)
+	if fetchErr != nil {
+		t.Fatal(fetchErr)
+	}
+	if fetched.checksum == "" {
+		t.Errorf("expected a checksum")
+	}
+
+	if _, err := ioutil.ReadFile("deps/piot/thunder/deps.toml"); err != nil {
+		t.Error(err)
+	}
+}
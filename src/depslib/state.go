@@ -0,0 +1,120 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const depsStateFilename = ".state.json"
+
+// PackageState is what a package directory in deps/ was extracted from.
+type PackageState struct {
+	Ref         string `json:"ref"`
+	Source      string `json:"source,omitempty"`
+	Commit      string `json:"commit"`
+	Checksum    string `json:"checksum"`
+	StripPrefix string `json:"stripPrefix,omitempty"`
+}
+
+// DepsState is stored as deps/.state.json, so that the next fetch can keep the packages that did not change.
+type DepsState struct {
+	Packages map[string]PackageState `json:"packages"`
+}
+
+func NewDepsState() *DepsState {
+	return &DepsState{Packages: make(map[string]PackageState)}
+}
+
+// Find returns nil if the package is not in the state. It is safe to call on a nil state.
+func (s *DepsState) Find(name string) *PackageState {
+	if s == nil {
+		return nil
+	}
+	state, wasFound := s.Packages[name]
+	if !wasFound {
+		return nil
+	}
+	return &state
+}
+
+// ReadDepsState returns an empty state if deps/ has no state file.
+func ReadDepsState(depsPath string) (*DepsState, error) {
+	filename := filepath.Join(depsPath, depsStateFilename)
+	content, readErr := ioutil.ReadFile(filename)
+	if os.IsNotExist(readErr) {
+		return NewDepsState(), nil
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	state := NewDepsState()
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
+	if state.Packages == nil {
+		state.Packages = make(map[string]PackageState)
+	}
+	return state, nil
+}
+
+func WriteDepsState(depsPath string, state *DepsState) error {
+	content, marshalErr := json.MarshalIndent(state, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return ioutil.WriteFile(filepath.Join(depsPath, depsStateFilename), append(content, '\n'), 0644)
+}
+
+func depsStateFromCache(cache *Cache) *DepsState {
+	state := NewDepsState()
+	for name, node := range cache.Nodes {
+		if node.checksum == "" {
+			continue
+		}
+		state.Packages[name] = PackageState{Ref: node.ref.String(), Source: node.source, Commit: node.commit,
+			Checksum: node.checksum, StripPrefix: node.stripPrefix}
+	}
+	return state
+}
+
+// RemovedPackages returns the packages that were in the previous state, but are not in this one.
+func (s *DepsState) RemovedPackages(previous *DepsState) []string {
+	var names []string
+	for name := range previous.Packages {
+		if _, wasFound := s.Packages[name]; !wasFound {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isUnchanged(previous *PackageState, checksum string, stripPrefix string) bool {
+	return previous != nil && previous.Checksum == checksum && previous.StripPrefix == stripPrefix
+}
+
+// isPinnedAndUnchanged is true if the previous fetch already has the tag, revision or locked commit that is
+// asked for, so the package does not have to be downloaded to find out if it changed. Branches without
+// a locked commit can move, so they are always downloaded.
+func isPinnedAndUnchanged(previous *PackageState, target fetchTarget) bool {
+	if previous == nil || previous.Ref != target.ref.String() || previous.StripPrefix != target.stripPrefix {
+		return false
+	}
+	if target.expectedChecksum != "" && target.expectedChecksum != previous.Checksum {
+		return false
+	}
+	if target.locked != nil && target.locked.Commit != "" {
+		return target.locked.Commit == previous.Commit
+	}
+	return target.ref.Kind == Tag || target.ref.Kind == Revision
+}
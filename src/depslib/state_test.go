@@ -0,0 +1,85 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestIncrementalFetch(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	rootPath := t.TempDir()
+	appConfig := `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+source = "` + env.source() + `"
+`
+	writeTestPackage(t, rootPath, "piot/app", appConfig+`
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+	depsPath := filepath.Join(rootPath, "piot/app/deps")
+
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	state, stateErr := ReadDepsState(depsPath)
+	if stateErr != nil {
+		t.Fatal(stateErr)
+	}
+	thunderState := state.Find("piot/thunder")
+	if thunderState == nil || thunderState.Ref != "tag:v1" || thunderState.Checksum == "" {
+		t.Fatalf("wrong state %v", state)
+	}
+
+	markerFilename := filepath.Join(depsPath, "piot/thunder/marker")
+	writeTestFile(t, markerFilename, "kept")
+
+	// an empty download cache makes sure that only .state.json can avoid the download
+	t.Setenv("DEPS_CACHE_DIR", t.TempDir())
+	requestCount := env.requestCount.Load()
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if env.requestCount.Load() != requestCount {
+		t.Errorf("unchanged tag should not be downloaded, %d requests", env.requestCount.Load()-requestCount)
+	}
+	checkTestFile(t, markerFilename, "kept")
+	checkTestFile(t, filepath.Join(depsPath, "piot/thunder/deps.toml"), "depsversion = \"0.0.0\"\nname = \"piot/thunder\"\nversion = \"1.0.0\"\n")
+
+	writeTestPackage(t, rootPath, "piot/app", appConfig+`
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+
+[[dependencies]]
+name = "piot/missing"
+version = "*"
+tag = "v1"
+`)
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err == nil {
+		t.Fatalf("expected error for missing package")
+	}
+	checkTestFile(t, markerFilename, "kept")
+
+	writeTestPackage(t, rootPath, "piot/app", appConfig)
+	if _, err := SetupDependencies(configFilename, SetupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if directoryExists(filepath.Join(depsPath, "piot/thunder")) {
+		t.Errorf("removed dependency should be removed from deps/")
+	}
+	state, stateErr = ReadDepsState(depsPath)
+	if stateErr != nil || len(state.Packages) != 0 {
+		t.Errorf("state should be empty %v %v", state, stateErr)
+	}
+}
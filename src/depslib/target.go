@@ -0,0 +1,71 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// TargetOperatingSystems are the names that can be used in [target.<os>] sections and with --target-os.
+var TargetOperatingSystems = []string{"linux", "macos", "windows"}
+
+// Target is a [target.<os>] section with dependencies that are only used when fetching for that operating system.
+type Target struct {
+	Dependencies []Package
+}
+
+// DefaultTargetOS is the operating system that deps is running on.
+func DefaultTargetOS() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "macos"
+	case "windows":
+		return "windows"
+	}
+	return "linux"
+}
+
+func ValidateTargetOS(targetOS string) error {
+	for _, name := range TargetOperatingSystems {
+		if name == targetOS {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown target operating system '%v', expected one of %v", targetOS, strings.Join(TargetOperatingSystems, ", "))
+}
+
+func (c *Config) validateTargets() error {
+	for targetOS, target := range c.Target {
+		if err := ValidateTargetOS(targetOS); err != nil {
+			return fmt.Errorf("[target.%v]: %w", targetOS, err)
+		}
+		for _, dep := range target.Dependencies {
+			if err := validatePackageRef(dep); err != nil {
+				return err
+			}
+			if dep.Optional {
+				return fmt.Errorf("[target.%v] dependency '%v' can not be optional, features only enable [[dependencies]]", targetOS, dep.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// dependenciesForTarget are the required dependencies, and the dependencies of the target operating system.
+func (c *Config) dependenciesForTarget(targetOS string) []Package {
+	return append(c.requiredDependencies(), c.Target[targetOS].Dependencies...)
+}
+
+// ValidateHostTargetOS returns an error if targetOS is set and is not the operating system deps is running on.
+// The compiler always builds for the host, so building with the dependencies of another target can not work.
+func ValidateHostTargetOS(targetOS string) error {
+	if targetOS != "" && targetOS != DefaultTargetOS() {
+		return fmt.Errorf("can only build for '%v', --target-os '%v' can only be used with fetch, graph and why", DefaultTargetOS(), targetOS)
+	}
+	return nil
+}
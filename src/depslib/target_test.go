@@ -0,0 +1,105 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTargetDependencies(t *testing.T) {
+	isolateTestEnvironment(t)
+	rootPath := t.TempDir()
+	writeTestPackage(t, rootPath, "piot/app", `depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+
+[[dependencies]]
+name = "piot/input"
+version = "*"
+`)
+	writeTestPackage(t, rootPath, "piot/input", `depsversion = "0.0.0"
+name = "piot/input"
+version = "1.0.0"
+
+[[target.linux.dependencies]]
+name = "piot/input-evdev"
+version = "*"
+
+[[target.windows.dependencies]]
+name = "piot/input-xinput"
+version = "*"
+`)
+	writeTestPackage(t, rootPath, "piot/input-evdev", testPackageContent("piot/input-evdev"))
+	writeTestPackage(t, rootPath, "piot/input-xinput", testPackageContent("piot/input-xinput"))
+	configFilename := filepath.Join(rootPath, "piot/app/deps.toml")
+
+	for _, test := range []struct {
+		targetOS string
+		expected []string
+	}{
+		{"linux", []string{"piot/input", "piot/input-evdev"}},
+		{"windows", []string{"piot/input", "piot/input-xinput"}},
+		{"macos", []string{"piot/input"}},
+	} {
+		info, err := SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath, TargetOS: test.targetOS})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := sortedNodeNames(info.RootNodes); strings.Join(names, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("%v: expected %v, got %v", test.targetOS, test.expected, names)
+		}
+	}
+
+	_, err := SetupDependencies(configFilename, SetupOptions{Mode: ReadLocal, LocalPackageRoot: rootPath, TargetOS: "amiga"})
+	if err == nil {
+		t.Errorf("expected error for unknown target")
+	}
+}
+
+func TestUnknownTargetSection(t *testing.T) {
+	_, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+
+[[target.linux-gnu.dependencies]]
+name = "piot/input-evdev"
+version = "*"
+`))
+	if err == nil {
+		t.Errorf("expected error for unknown target operating system")
+	}
+}
+
+func TestOptionalTargetDependency(t *testing.T) {
+	_, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/app"
+version = "1.0.0"
+
+[[target.linux.dependencies]]
+name = "piot/input-evdev"
+version = "*"
+optional = true
+`))
+	if err == nil {
+		t.Errorf("expected error for optional target dependency")
+	}
+}
+
+func TestHostTargetOS(t *testing.T) {
+	if err := ValidateHostTargetOS(""); err != nil {
+		t.Errorf("no target should build for the host: %v", err)
+	}
+	if err := ValidateHostTargetOS(DefaultTargetOS()); err != nil {
+		t.Errorf("host target should be allowed: %v", err)
+	}
+	for _, targetOS := range TargetOperatingSystems {
+		if targetOS != DefaultTargetOS() && ValidateHostTargetOS(targetOS) == nil {
+			t.Errorf("expected error when building for '%v'", targetOS)
+		}
+	}
+}
@@ -18,22 +18,47 @@ import (
 )
 
 type Package struct {
-	Version string
-	Name    string
+	Version     string
+	Name        string
+	Tag         string
+	Branch      string
+	Rev         string
+	Source      string
+	Sha256      string
+	StripPrefix string `toml:"strip_prefix"`
+	Path        string
+	Optional    bool
 }
 
 func (p Package) String() string {
 	return fmt.Sprintf("name:%v version:%v", p.Name, p.Version)
 }
 
+func (p Package) Ref() Ref {
+	switch {
+	case p.Rev != "":
+		return Ref{Kind: Revision, Name: p.Rev}
+	case p.Tag != "":
+		return Ref{Kind: Tag, Name: p.Tag}
+	case p.Branch != "":
+		return Ref{Kind: Branch, Name: p.Branch}
+	}
+	return Ref{Kind: DefaultBranch}
+}
+
 type Config struct {
 	DepsVersion  string
 	Version      string
 	Name         string
 	LibraryName  string
 	ArtifactType string
+	Source       string
 	Dependencies []Package
 	Development  []Package
+	Features     map[string][]string
+	Target       map[string]Target
+	Workspace    *Workspace
+	filename     string
 }
 
 func RepoNameToShortName(repo string) string {
@@ -59,6 +84,18 @@ func ReadFromReader(reader io.Reader) (*Config, error) {
 		return nil, fmt.Errorf("wrong deps file format version '%v'", config.DepsVersion)
 	}
 
+	for _, dep := range append(append([]Package{}, config.Dependencies...), config.Development...) {
+		if err := validatePackageRef(dep); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.validateFeatures(); err != nil {
+		return nil, err
+	}
+	if err := config.validateTargets(); err != nil {
+		return nil, err
+	}
+
 	return config, unmarshalErr
 }
 
@@ -67,7 +104,14 @@ func ReadConfigFromFilename(filename string) (*Config, error) {
 	if openErr != nil {
 		return nil, openErr
 	}
-	return ReadFromReader(reader)
+	defer reader.Close()
+
+	config, readErr := ReadFromReader(reader)
+	if readErr != nil {
+		return nil, fmt.Errorf("%v: %w", filename, readErr)
+	}
+	config.filename = filename
+	return config, nil
 }
 
 func ReadConfigFromDirectory(directory string) (*Config, error) {
@@ -6,6 +6,7 @@
 package depslib
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -25,3 +26,86 @@ func TestToml(t *testing.T) {
 		t.Errorf("wrong package name %v", packageNameToTest)
 	}
 }
+
+func TestDependencyRef(t *testing.T) {
+	conf, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/lightning"
+version = "0.0.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1.2.0"
+
+[[dependencies]]
+name = "piot/tiny-clib"
+version = "*"
+branch = "master"
+
+[[dependencies]]
+name = "piot/burst"
+version = "*"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRefs := []Ref{{Kind: Tag, Name: "v1.2.0"}, {Kind: Branch, Name: "master"}, {Kind: DefaultBranch}}
+	for index, expectedRef := range expectedRefs {
+		ref := conf.Dependencies[index].Ref()
+		if ref != expectedRef {
+			t.Errorf("wrong ref for %v: %v", conf.Dependencies[index].Name, ref)
+		}
+	}
+}
+
+func TestDependencyMultipleRefs(t *testing.T) {
+	_, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/lightning"
+version = "0.0.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1.2.0"
+rev = "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+`))
+	if err == nil {
+		t.Errorf("expected error for dependency with both tag and rev")
+	}
+}
+
+func TestDependencyPathWithRef(t *testing.T) {
+	_, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/lightning"
+version = "0.0.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+path = "../thunder"
+tag = "v1.2.0"
+`))
+	if err == nil {
+		t.Errorf("expected error for dependency with both path and tag")
+	}
+}
+
+func TestDependencyStripPrefix(t *testing.T) {
+	conf, err := ReadFromReader(strings.NewReader(`depsversion = "0.0.0"
+name = "piot/lightning"
+version = "0.0.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+strip_prefix = "thunder-release"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Dependencies[0].StripPrefix != "thunder-release" {
+		t.Errorf("wrong strip_prefix '%v'", conf.Dependencies[0].StripPrefix)
+	}
+}
@@ -0,0 +1,204 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+type VersionConstraint struct {
+	text     string
+	versions semver.Range
+}
+
+func (c *VersionConstraint) Check(v semver.Version) bool {
+	return c.versions(v)
+}
+
+func (c *VersionConstraint) String() string {
+	return c.text
+}
+
+func isAnyVersion(s string) bool {
+	return s == "" || s == "*" || s == "x" || s == "X"
+}
+
+func parsePartialVersion(s string) ([]uint64, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("too many version components in '%v'", s)
+	}
+	var numbers []uint64
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		number, parseErr := strconv.ParseUint(part, 10, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("illegal version component '%v' in '%v'", part, s)
+		}
+		numbers = append(numbers, number)
+	}
+	return numbers, nil
+}
+
+func versionFromNumbers(numbers []uint64) string {
+	complete := []uint64{0, 0, 0}
+	copy(complete, numbers)
+	return fmt.Sprintf("%d.%d.%d", complete[0], complete[1], complete[2])
+}
+
+// expandTilde converts ~1.4 to ">=1.4.0 <1.5.0" and ~1 to ">=1.0.0 <2.0.0".
+func expandTilde(s string) (string, error) {
+	numbers, parseErr := parsePartialVersion(s)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	if len(numbers) == 0 {
+		return "", nil
+	}
+	upper := []uint64{numbers[0] + 1}
+	if len(numbers) > 1 {
+		upper = []uint64{numbers[0], numbers[1] + 1}
+	}
+	return fmt.Sprintf(">=%v <%v", versionFromNumbers(numbers), versionFromNumbers(upper)), nil
+}
+
+// expandCaret converts ^1.2.3 to ">=1.2.3 <2.0.0", keeping the leftmost non-zero component fixed.
+func expandCaret(s string) (string, error) {
+	numbers, parseErr := parsePartialVersion(s)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	if len(numbers) == 0 {
+		return "", nil
+	}
+	var upper []uint64
+	for index, number := range numbers {
+		if number != 0 || index == len(numbers)-1 {
+			upper = append(append([]uint64{}, numbers[:index]...), number+1)
+			break
+		}
+	}
+	return fmt.Sprintf(">=%v <%v", versionFromNumbers(numbers), versionFromNumbers(upper)), nil
+}
+
+// expandPartialComparator fills in missing components, so ">=1.2" becomes ">=1.2.0" and "1.4" becomes "1.4.x".
+func expandPartialComparator(s string) (string, error) {
+	operatorLength := strings.IndexAny(s, "0123456789")
+	if operatorLength < 0 {
+		return "", fmt.Errorf("illegal version constraint '%v'", s)
+	}
+	operator := s[:operatorLength]
+	versionString := s[operatorLength:]
+	if strings.ContainsAny(versionString, "-+") || strings.Count(versionString, ".") == 2 {
+		return s, nil
+	}
+	if strings.ContainsAny(versionString, "xX*") {
+		return operator + strings.NewReplacer("X", "x", "*", "x").Replace(versionString), nil
+	}
+	if operator == "" || operator == "=" || operator == "==" {
+		return versionString + ".x", nil
+	}
+	numbers, parseErr := parsePartialVersion(versionString)
+	if parseErr != nil {
+		return "", parseErr
+	}
+	return operator + versionFromNumbers(numbers), nil
+}
+
+func expandConstraintPart(part string) (string, error) {
+	switch {
+	case isAnyVersion(part):
+		return "", nil
+	case strings.HasPrefix(part, "~"):
+		return expandTilde(strings.TrimPrefix(strings.TrimPrefix(part, "~"), ">"))
+	case strings.HasPrefix(part, "^"):
+		return expandCaret(strings.TrimPrefix(part, "^"))
+	default:
+		return expandPartialComparator(part)
+	}
+}
+
+// ParseVersionConstraint parses the version field of a dependency in deps.toml.
+// In addition to the github.com/blang/semver range syntax it supports "*", ~1.4 and ^1.2.
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	text := strings.TrimSpace(s)
+	if isAnyVersion(text) {
+		return &VersionConstraint{text: "*", versions: func(semver.Version) bool { return true }}, nil
+	}
+
+	var alternatives []string
+	for _, alternative := range strings.Split(text, "||") {
+		var expandedParts []string
+		for _, part := range strings.Fields(alternative) {
+			expanded, expandErr := expandConstraintPart(part)
+			if expandErr != nil {
+				return nil, expandErr
+			}
+			if expanded != "" {
+				expandedParts = append(expandedParts, expanded)
+			}
+		}
+		if len(expandedParts) == 0 {
+			expandedParts = append(expandedParts, ">=0.0.0")
+		}
+		alternatives = append(alternatives, strings.Join(expandedParts, " "))
+	}
+
+	versionRange, rangeErr := semver.ParseRange(strings.Join(alternatives, " || "))
+	if rangeErr != nil {
+		return nil, fmt.Errorf("illegal version constraint '%v': %v", text, rangeErr)
+	}
+
+	return &VersionConstraint{text: text, versions: versionRange}, nil
+}
+
+type VersionRequirement struct {
+	RequiredBy string
+	Constraint *VersionConstraint
+}
+
+func (r VersionRequirement) String() string {
+	return fmt.Sprintf("'%v' requires '%v'", r.RequiredBy, r.Constraint)
+}
+
+type VersionConflictError struct {
+	Name         string
+	Resolved     semver.Version
+	Requirements []VersionRequirement
+}
+
+func (e *VersionConflictError) Error() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("version conflict for '%v', resolved version %v:", e.Name, e.Resolved))
+	for _, requirement := range e.Requirements {
+		status := "ok"
+		if !requirement.Constraint.Check(e.Resolved) {
+			status = "not satisfied"
+		}
+		lines = append(lines, fmt.Sprintf("  %v (%v)", requirement, status))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func checkVersionRequirements(name string, resolved semver.Version, requirements []VersionRequirement) error {
+	for _, requirement := range requirements {
+		if !requirement.Constraint.Check(resolved) {
+			sortedRequirements := append([]VersionRequirement{}, requirements...)
+			sort.SliceStable(sortedRequirements, func(i, j int) bool {
+				return sortedRequirements[i].RequiredBy < sortedRequirements[j].RequiredBy
+			})
+			return &VersionConflictError{Name: name, Resolved: resolved, Requirements: sortedRequirements}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"*", "0.0.0", true},
+		{"", "3.1.4", true},
+		{">=1.2.0 <2.0.0", "1.2.0", true},
+		{">=1.2.0 <2.0.0", "1.9.9", true},
+		{">=1.2.0 <2.0.0", "2.0.0", false},
+		{">=1.2.0 <2.0.0", "1.1.9", false},
+		{"~1.4", "1.4.7", true},
+		{"~1.4", "1.5.0", false},
+		{"~1.4.2", "1.4.1", false},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+		{"^0.2.1", "0.3.0", false},
+		{"1.4", "1.4.3", true},
+		{"<1.0.0 || >=2.0.0", "1.5.0", false},
+		{"<1.0.0 || >=2.0.0", "2.5.0", true},
+	}
+
+	for _, test := range tests {
+		constraint, err := ParseVersionConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf("'%v': %v", test.constraint, err)
+		}
+		result := constraint.Check(semver.MustParse(test.version))
+		if result != test.expected {
+			t.Errorf("'%v' check %v: expected %v, got %v", test.constraint, test.version, test.expected, result)
+		}
+	}
+}
+
+func TestIllegalVersionConstraint(t *testing.T) {
+	_, err := ParseVersionConstraint("~one.two")
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func writeTestPackage(t *testing.T, rootPath string, name string, content string) {
+	directory := filepath.Join(rootPath, name)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(directory, "deps.toml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVersionConflict(t *testing.T) {
+	rootPath := t.TempDir()
+
+	writeTestPackage(t, rootPath, "piot/a", `depsversion = "0.0.0"
+name = "piot/a"
+version = "1.0.0"
+
+[[dependencies]]
+name = "piot/b"
+version = "*"
+
+[[dependencies]]
+name = "piot/c"
+version = ">=1.2.0 <2.0.0"
+`)
+	writeTestPackage(t, rootPath, "piot/b", `depsversion = "0.0.0"
+name = "piot/b"
+version = "0.1.0"
+
+[[dependencies]]
+name = "piot/c"
+version = "~2.1"
+`)
+	writeTestPackage(t, rootPath, "piot/c", `depsversion = "0.0.0"
+name = "piot/c"
+version = "1.4.0"
+`)
+
+	conf, confErr := ReadConfigFromDirectory(filepath.Join(rootPath, "piot/a"))
+	if confErr != nil {
+		t.Fatal(confErr)
+	}
+
+	_, _, err := CalculateTotalDependencies(rootPath, "", conf, ReadLocal, false, nil, nil, 0)
+	var conflictErr *VersionConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected version conflict, got %v", err)
+	}
+	if conflictErr.Name != "piot/c" || len(conflictErr.Requirements) != 2 {
+		t.Errorf("wrong conflict %v", conflictErr)
+	}
+	if !strings.Contains(err.Error(), "'piot/b' requires '~2.1' (not satisfied)") {
+		t.Errorf("unexpected report %v", err)
+	}
+}
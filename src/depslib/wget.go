@@ -6,6 +6,7 @@
 package depslib
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -21,6 +22,10 @@ func HTTPGet(downloadURL *url.URL, targetFile string) (err error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("could not download '%v': %v", downloadURL.Redacted(), resp.Status)
+	}
+
 	out, createErr := os.Create(targetFile)
 	if createErr != nil {
 		return createErr
@@ -0,0 +1,79 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DependencyPath is one way that the root node ends up depending on a package.
+// Development[index] is true if the edge from Nodes[index] to Nodes[index+1] is a development dependency.
+type DependencyPath struct {
+	Nodes       []*DependencyNode
+	Development []bool
+}
+
+func (p DependencyPath) String() string {
+	names := nodeNames(p.Nodes)
+	for index, isDevelopment := range p.Development {
+		if isDevelopment {
+			names[index+1] += " (dev)"
+		}
+	}
+	return strings.Join(names, " -> ")
+}
+
+// RedundantEdges returns, for each edge in the path that SetupDependencies reports as redundant,
+// the other dependencies that already require the same package. The key is the index of the edge.
+func (p DependencyPath) RedundantEdges() map[int][]*DependencyNode {
+	redundant := make(map[int][]*DependencyNode)
+	for index := 0; index < len(p.Nodes)-1; index++ {
+		if p.Development[index] {
+			continue
+		}
+		alreadyRequiredBy := whoDependsOnThisExcept(p.Nodes[index].dependencies, p.Nodes[index+1])
+		if len(alreadyRequiredBy) > 0 {
+			redundant[index] = alreadyRequiredBy
+		}
+	}
+	return redundant
+}
+
+func collectDependencyPaths(node *DependencyNode, name string, stack []*DependencyNode, development []bool, paths []DependencyPath) []DependencyPath {
+	if isInList(stack, node) {
+		// a development dependency can depend on the package that uses it
+		return paths
+	}
+	stack = append(stack, node)
+	if node.name == name {
+		return append(paths, DependencyPath{Nodes: append([]*DependencyNode{}, stack...),
+			Development: append([]bool{}, development...)})
+	}
+
+	for _, dependency := range node.dependencies {
+		paths = collectDependencyPaths(dependency, name, stack, append(development, false), paths)
+	}
+	for _, dependency := range node.development {
+		paths = collectDependencyPaths(dependency, name, stack, append(development, true), paths)
+	}
+
+	return paths
+}
+
+// Why returns every path from the root node to the named package.
+func Why(rootNode *DependencyNode, name string) ([]DependencyPath, error) {
+	if rootNode.name == name {
+		return nil, fmt.Errorf("'%v' is the root package", name)
+	}
+
+	paths := collectDependencyPaths(rootNode, name, nil, nil, nil)
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("'%v' is not a dependency of '%v'", name, rootNode.name)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,159 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Workspace is the [workspace] section of a deps.toml. Members are directories with a deps.toml, relative to the
+// workspace deps.toml, and can be glob patterns like "libs/*".
+type Workspace struct {
+	Members []string
+}
+
+const defaultWorkspaceName = "workspace"
+
+func (w *Workspace) memberFilenames(workspaceDirectory string) ([]string, error) {
+	var filenames []string
+	for _, member := range w.Members {
+		matches, globErr := filepath.Glob(filepath.Join(workspaceDirectory, member, "deps.toml"))
+		if globErr != nil {
+			return nil, fmt.Errorf("workspace member '%v': %w", member, globErr)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("workspace member '%v' has no deps.toml in '%v'", member, workspaceDirectory)
+		}
+		filenames = append(filenames, matches...)
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// findWorkspace returns the filename of the workspace deps.toml that the package is a member of, or that the
+// package is itself. It returns an empty string if the package is not in a workspace.
+func findWorkspace(filename string, conf *Config) (string, *Config, error) {
+	if conf.Workspace != nil {
+		return filename, conf, nil
+	}
+
+	absoluteFilename, absErr := filepath.Abs(filename)
+	if absErr != nil {
+		return "", nil, absErr
+	}
+
+	foundFilenames, _ := find(filepath.Dir(filepath.Dir(absoluteFilename)))
+	for _, foundFilename := range foundFilenames {
+		foundConf, readErr := ReadConfigFromFilename(foundFilename)
+		if readErr != nil || foundConf.Workspace == nil {
+			continue
+		}
+		memberFilenames, membersErr := foundConf.Workspace.memberFilenames(filepath.Dir(foundFilename))
+		if membersErr != nil {
+			return "", nil, fmt.Errorf("%v: %w", foundFilename, membersErr)
+		}
+		for _, memberFilename := range memberFilenames {
+			if memberFilename == absoluteFilename {
+				return foundFilename, foundConf, nil
+			}
+		}
+	}
+
+	return "", nil, nil
+}
+
+func readWorkspaceMembers(workspaceFilename string, workspaceConf *Config) ([]*Config, error) {
+	if len(workspaceConf.Dependencies) > 0 || len(workspaceConf.Development) > 0 {
+		return nil, fmt.Errorf("%v: a workspace can not have dependencies, add them to a member", workspaceFilename)
+	}
+
+	memberFilenames, membersErr := workspaceConf.Workspace.memberFilenames(filepath.Dir(workspaceFilename))
+	if membersErr != nil {
+		return nil, fmt.Errorf("%v: %w", workspaceFilename, membersErr)
+	}
+
+	var members []*Config
+	for _, memberFilename := range memberFilenames {
+		memberConf, readErr := ReadConfigFromFilename(memberFilename)
+		if readErr != nil {
+			return nil, readErr
+		}
+		members = append(members, memberConf)
+	}
+	return members, nil
+}
+
+func newWorkspaceNode(workspaceConf *Config) *DependencyNode {
+	name := workspaceConf.Name
+	if name == "" {
+		name = defaultWorkspaceName
+	}
+	return &DependencyNode{name: name, configFilename: workspaceConf.filename, local: true}
+}
+
+// calculateWorkspaceDependencies resolves all members into one graph. The members are added to the cache before
+// anything is resolved, so members that depend on each other use the local member instead of fetching it.
+func calculateWorkspaceDependencies(rootPath string, depsPath string, workspaceConf *Config, members []*Config, mode Mode, useDevelopmentDependencies bool, cache *Cache) (*DependencyNode, error) {
+	cache.RootSource = workspaceConf.Source
+	workspaceNode := newWorkspaceNode(workspaceConf)
+
+	memberNodes := make([]*DependencyNode, len(members))
+	for index, memberConf := range members {
+		if existingNode := cache.FindNode(memberConf.Name); existingNode != nil {
+			return nil, fmt.Errorf("workspace member '%v' is in both '%v' and '%v'", memberConf.Name,
+				existingNode.configFilename, memberConf.filename)
+		}
+		memberNode, nodeErr := newDependencyNode(memberConf)
+		if nodeErr != nil {
+			return nil, nodeErr
+		}
+		memberNode.local = true
+		cache.AddNode(memberConf.Name, memberNode)
+		memberNodes[index] = memberNode
+		workspaceNode.AddDependency(memberNode)
+	}
+
+	for index, memberConf := range members {
+		if err := resolveDependencies(rootPath, depsPath, memberNodes[index], memberConf, cache, mode, useDevelopmentDependencies); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkForCycles(cache, workspaceNode); err != nil {
+		return nil, err
+	}
+
+	return workspaceNode, cache.CheckRequirements()
+}
+
+// linkWorkspaceMembers makes the src/ of the members available in the shared deps/, the same way as Symlink mode.
+func linkWorkspaceMembers(depsPath string, members []*Config) error {
+	for _, memberConf := range members {
+		memberSource, absErr := filepath.Abs(filepath.Join(filepath.Dir(memberConf.filename), "src"))
+		if absErr != nil {
+			return absErr
+		}
+		if !directoryExists(memberSource) {
+			continue
+		}
+
+		linkName := filepath.Join(depsPath, RepoNameToShortName(memberConf.Name), "src")
+		if stat, statErr := os.Lstat(linkName); statErr == nil && stat.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("there is already something at target '%v', can not link workspace member", linkName)
+		}
+
+		log.Printf("link workspace member '%v' to '%v'\n", memberSource, linkName)
+		if err := MakeSymlink(memberSource, linkName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
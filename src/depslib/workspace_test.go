@@ -0,0 +1,125 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depslib
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func writeTestWorkspace(t *testing.T, sourceURL string) string {
+	workspaceDirectory := t.TempDir()
+	writeTestFile(t, filepath.Join(workspaceDirectory, "deps.toml"), `depsversion = "0.0.0"
+name = "piot/monorepo"
+source = "`+sourceURL+`/{repo}-{ref}.zip"
+
+[workspace]
+members = ["libs/*", "apps/app"]
+`)
+	writeTestFile(t, filepath.Join(workspaceDirectory, "libs/a/deps.toml"), `depsversion = "0.0.0"
+name = "piot/a"
+version = "1.0.0"
+
+[[dependencies]]
+name = "piot/b"
+version = "^1.0.0"
+
+[[dependencies]]
+name = "piot/thunder"
+version = "*"
+tag = "v1"
+`)
+	writeTestFile(t, filepath.Join(workspaceDirectory, "libs/b/deps.toml"), `depsversion = "0.0.0"
+name = "piot/b"
+version = "1.2.0"
+`)
+	writeTestFile(t, filepath.Join(workspaceDirectory, "libs/b/src/lib/b.c"), "")
+	writeTestFile(t, filepath.Join(workspaceDirectory, "apps/app/deps.toml"), `depsversion = "0.0.0"
+name = "piot/app"
+version = "0.1.0"
+
+[[dependencies]]
+name = "piot/a"
+version = "*"
+`)
+	return workspaceDirectory
+}
+
+func sortedNodeNames(nodes []*DependencyNode) []string {
+	names := nodeNames(nodes)
+	sort.Strings(names)
+	return names
+}
+
+func TestWorkspace(t *testing.T) {
+	env := newTestEnvironment(t)
+
+	workspaceDirectory := writeTestWorkspace(t, env.server.URL)
+
+	info, err := SetupDependencies(filepath.Join(workspaceDirectory, "deps.toml"), SetupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RootNode.Name() != "piot/monorepo" {
+		t.Errorf("expected workspace root, got %v", info.RootNode)
+	}
+	if names := sortedNodeNames(info.RootNode.Dependencies()); len(names) != 3 || names[0] != "piot/a" || names[2] != "piot/b" {
+		t.Errorf("workspace should depend on all members %v", names)
+	}
+	if env.requestCount.Load() != 1 {
+		t.Errorf("only piot/thunder should be downloaded, %d requests", env.requestCount.Load())
+	}
+
+	depsPath := filepath.Join(workspaceDirectory, "deps")
+	if !fileExists(filepath.Join(depsPath, "piot/thunder/deps.toml")) {
+		t.Errorf("piot/thunder should be in the shared deps/")
+	}
+	if !fileExists(filepath.Join(depsPath, "piot/b/src/lib/b.c")) {
+		t.Errorf("workspace member should be linked into deps/")
+	}
+	if directoryExists(filepath.Join(workspaceDirectory, "libs/a/deps")) {
+		t.Errorf("members should not get their own deps/")
+	}
+
+	lockFile, lockErr := ReadLockFile(filepath.Join(workspaceDirectory, "deps.lock"))
+	if lockErr != nil || lockFile == nil || len(lockFile.Packages) != 1 || lockFile.Packages[0].Name != "piot/thunder" {
+		t.Errorf("only fetched packages should be locked %v %v", lockFile, lockErr)
+	}
+
+	memberInfo, memberErr := SetupDependencies(filepath.Join(workspaceDirectory, "apps/app/deps.toml"), SetupOptions{})
+	if memberErr != nil {
+		t.Fatal(memberErr)
+	}
+	if memberInfo.RootNode.Name() != "piot/app" || memberInfo.DepsPath != depsPath {
+		t.Errorf("member should be the root and use the workspace deps/ %v %v", memberInfo.RootNode, memberInfo.DepsPath)
+	}
+	if names := sortedNodeNames(memberInfo.RootNodes); len(names) != 3 || names[0] != "piot/a" || names[1] != "piot/b" || names[2] != "piot/thunder" {
+		t.Errorf("member should only get its own dependencies %v", names)
+	}
+}
+
+func TestWorkspaceMemberVersionConflict(t *testing.T) {
+	isolateTestEnvironment(t)
+	workspaceDirectory := writeTestWorkspace(t, "https://files.internal")
+	writeTestFile(t, filepath.Join(workspaceDirectory, "libs/b/deps.toml"), `depsversion = "0.0.0"
+name = "piot/b"
+version = "2.0.0"
+`)
+	writeTestFile(t, filepath.Join(workspaceDirectory, "libs/a/deps.toml"), `depsversion = "0.0.0"
+name = "piot/a"
+version = "1.0.0"
+
+[[dependencies]]
+name = "piot/b"
+version = "^1.0.0"
+`)
+
+	_, err := SetupDependencies(filepath.Join(workspaceDirectory, "deps.toml"), SetupOptions{Mode: ReadLocal, LocalPackageRoot: workspaceDirectory})
+	if _, isConflict := err.(*VersionConflictError); !isConflict {
+		t.Errorf("expected version conflict with local member, got %v", err)
+	}
+}
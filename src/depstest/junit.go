@@ -0,0 +1,86 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depstest
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+)
+
+type junitFailure struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+func junitSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
+
+// WriteJUnit writes the results as a JUnit XML test suite named after the package.
+func WriteJUnit(writer io.Writer, packageName string, results []Result) error {
+	suite := junitTestSuite{Name: packageName, Tests: len(results), Failures: failedCount(results)}
+	var total float64
+	for _, result := range results {
+		testCase := junitTestCase{Name: result.Name, ClassName: packageName,
+			Time: junitSeconds(result.Duration.Seconds()), SystemOut: result.Output}
+		if !result.Passed() {
+			message := fmt.Sprintf("exit code %d", result.ExitCode)
+			if result.Err != nil {
+				message = result.Err.Error()
+			}
+			testCase.Failure = &junitFailure{Message: message, Contents: result.Output}
+		}
+		total += result.Duration.Seconds()
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	suite.Time = junitSeconds(total)
+
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, "\n")
+
+	return err
+}
+
+func WriteJUnitFile(filename string, packageName string, results []Result) error {
+	file, createErr := os.Create(filename)
+	if createErr != nil {
+		return createErr
+	}
+
+	writeErr := WriteJUnit(file, packageName, results)
+	closeErr := file.Close()
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return closeErr
+}
@@ -0,0 +1,124 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depstest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/piot/deps/src/ccompile"
+	"github.com/piot/deps/src/depslib"
+)
+
+// Result is the outcome of running one test executable.
+type Result struct {
+	Name     string
+	ExitCode int
+	Duration time.Duration
+	Output   string
+	Err      error
+}
+
+func (r Result) Passed() bool {
+	return r.Err == nil && r.ExitCode == 0
+}
+
+// RunExecutable runs a test executable in workingDirectory. A non-zero exit code is a failed test.
+func RunExecutable(executable string, workingDirectory string) Result {
+	result := Result{Name: filepath.Base(executable)}
+
+	var output bytes.Buffer
+	cmd := exec.Command(executable)
+	cmd.Dir = workingDirectory
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	runErr := cmd.Run()
+	result.Duration = time.Since(start)
+	result.Output = output.String()
+
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+	} else if runErr != nil {
+		result.Err = runErr
+		result.ExitCode = -1
+	}
+
+	return result
+}
+
+// RunExecutables runs all the test executables and reports each result on stdout.
+func RunExecutables(executables []string, workingDirectory string) []Result {
+	var results []Result
+	for _, executable := range executables {
+		result := RunExecutable(executable, workingDirectory)
+		if result.Passed() {
+			fmt.Printf("PASS %v (%.2fs)\n", result.Name, result.Duration.Seconds())
+		} else {
+			fmt.Print(result.Output)
+			if result.Err != nil {
+				fmt.Printf("FAIL %v (%.2fs): %v\n", result.Name, result.Duration.Seconds(), result.Err)
+			} else {
+				fmt.Printf("FAIL %v (%.2fs): exit code %d\n", result.Name, result.Duration.Seconds(), result.ExitCode)
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func failedCount(results []Result) int {
+	count := 0
+	for _, result := range results {
+		if !result.Passed() {
+			count++
+		}
+	}
+	return count
+}
+
+// Test builds src/test/*.c against the package and its development dependencies, runs every test executable
+// and optionally writes a JUnit XML report. It returns an error if a test could not be built or failed.
+func Test(info *depslib.DependencyInfo, junitFilename string) error {
+	outputDirectory, tempErr := ioutil.TempDir("", "deps-test")
+	if tempErr != nil {
+		return tempErr
+	}
+	defer os.RemoveAll(outputDirectory)
+
+	executables, buildErr := ccompile.BuildTests(info, outputDirectory)
+	if buildErr != nil {
+		return buildErr
+	}
+
+	if len(executables) == 0 {
+		fmt.Printf("no tests found in '%v'\n", filepath.Join(info.PackageRootPath, "src/test"))
+		return nil
+	}
+
+	results := RunExecutables(executables, info.PackageRootPath)
+
+	if junitFilename != "" {
+		if err := WriteJUnitFile(junitFilename, info.RootNode.Name(), results); err != nil {
+			return err
+		}
+	}
+
+	failed := failedCount(results)
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(results))
+	}
+
+	return nil
+}
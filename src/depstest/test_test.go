@@ -0,0 +1,55 @@
+/*---------------------------------------------------------------------------------------------
+ *  Copyright (c) Peter Bjorklund. All rights reserved.
+ *  Licensed under the MIT License. See LICENSE in the project root for license information.
+ *--------------------------------------------------------------------------------------------*/
+
+package depstest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestExecutable(t *testing.T, directory string, name string, script string) string {
+	filename := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(filename, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestRunExecutables(t *testing.T) {
+	directory := t.TempDir()
+	executables := []string{
+		writeTestExecutable(t, directory, "pass", "echo ok"),
+		writeTestExecutable(t, directory, "fail", "echo broken; exit 3"),
+	}
+
+	results := RunExecutables(executables, directory)
+	if !results[0].Passed() || results[0].Output != "ok\n" {
+		t.Errorf("expected 'pass' to pass, got %+v", results[0])
+	}
+	if results[1].Passed() || results[1].ExitCode != 3 {
+		t.Errorf("expected 'fail' to fail with exit code 3, got %+v", results[1])
+	}
+
+	var output bytes.Buffer
+	if err := WriteJUnit(&output, "piot/thunder", results); err != nil {
+		t.Fatal(err)
+	}
+
+	var suite junitTestSuite
+	if err := xml.Unmarshal(output.Bytes(), &suite); err != nil {
+		t.Fatal(err)
+	}
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("wrong counts in %v", output.String())
+	}
+	if suite.TestCases[0].Failure != nil || suite.TestCases[1].Failure == nil ||
+		suite.TestCases[1].Failure.Message != "exit code 3" {
+		t.Errorf("wrong failures in %v", output.String())
+	}
+}